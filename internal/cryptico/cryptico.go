@@ -2,18 +2,17 @@
 Package cryptico implements a symmetric encryption scheme to be used by the
 engine.
 
-The algorithm used is AES-256, and for this reason we need a 32 bit key length
-to operate correctly.
+The algorithm used is AES-256 in GCM mode, and for this reason we need a 32
+byte key length to operate correctly. GCM is an authenticated encryption
+mode: if the ciphertext has been tampered with or the key is not correct,
+Decrypt will fail with ErrAuthenticationFailed instead of returning garbage.
 
-As far as the CBC implementation is concerned, you can look here:
+The output of Encrypt is composed by the random nonce followed by the
+ciphertext and the authentication tag.
 
-https://stackoverflow.com/questions/18817336/golang-encrypting-a-string-with-aes-and-base64
-
-Stackoverflow? Really? Yes.
-
-IMPORTANT: this package don't implement any soft of HMAC-based message signing,
-meaning that if the key is not correct, your decoded text simply will not be
-correct. No error detected in that case.
+The previous scheme, based on AES-256 in CFB mode without any message
+authentication, is still available via EncryptCFB and DecryptCFB to read
+data encrypted by older versions of this package. Don't use it for new data.
 */
 package cryptico
 
@@ -21,23 +20,94 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 )
 
-// Encrypt encrypt the proposed data with the given key. The output string
-// will be hex encoded
+const (
+	// NonceSize is the size of the nonce at the start of the ciphertext
+	NonceSize = 12
+
+	// Overhead is the size of the authentication tag at the end of the
+	// ciphertext
+	Overhead = 16
+)
+
+var (
+	// ErrAuthenticationFailed is returned when the ciphertext can't be
+	// authenticated, meaning that the key is wrong or that the data has been
+	// tampered with
+	ErrAuthenticationFailed = errors.New("message authentication failed")
+)
+
+// Encrypt encrypt the proposed data with the given key. The output contains
+// the nonce followed by the authenticated ciphertext
 func Encrypt(data []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
+	return EncryptWithAD(data, nil, key)
+}
+
+// Decrypt works on the proposed data, returning ErrAuthenticationFailed if
+// the proposed key is not correct or if the data has been modified
+func Decrypt(data []byte, key []byte) ([]byte, error) {
+	return DecryptWithAD(data, nil, key)
+}
+
+// EncryptWithAD encrypt the proposed data with the given key, authenticating
+// also the associated data. The associated data is not included in the
+// output, and the same one must be passed to DecryptWithAD
+func EncryptWithAD(data []byte, ad []byte, key []byte) ([]byte, error) {
+	aead, err := newGCM(key)
 	if err != nil {
 		return nil, fmt.Errorf("Encrypt cipher allocation: %v", err)
 	}
 
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("Encrypt nonce generation: %v", err)
+	}
+
+	return aead.Seal(nonce, nonce, data, ad), nil
+}
+
+// DecryptWithAD decrypts data encrypted by EncryptWithAD, returning
+// ErrAuthenticationFailed if the key or the associated data are not correct
+// or if the data has been modified
+func DecryptWithAD(data []byte, ad []byte, key []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt cipher allocation: %v", err)
+	}
+
+	if len(data) < NonceSize+Overhead {
+		return nil, fmt.Errorf("Decrypt: too small ciphertext, no space for the nonce and the tag")
+	}
+
+	nonce := data[:aead.NonceSize()]
+	text := data[aead.NonceSize():]
+	destination, err := aead.Open(nil, nonce, text, ad)
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+
+	return destination, nil
+}
+
+// EncryptCFB encrypt the proposed data with the given key using the legacy
+// AES-CFB scheme, without any authentication. The output contains the
+// initial vector followed by the ciphertext
+func EncryptCFB(data []byte, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("EncryptCFB cipher allocation: %v", err)
+	}
+
 	cipherText := make([]byte, aes.BlockSize+len(data))
 	iv := cipherText[:aes.BlockSize]
 	_, err = io.ReadFull(rand.Reader, iv)
 	if err != nil {
-		return nil, fmt.Errorf("Encrypt IV generation: %v", err)
+		return nil, fmt.Errorf("EncryptCFB IV generation: %v", err)
 	}
 
 	cfb := cipher.NewCFBEncrypter(block, iv)
@@ -45,16 +115,17 @@ func Encrypt(data []byte, key []byte) ([]byte, error) {
 	return cipherText, nil
 }
 
-// Decrypt works on the proposed data, returning an error is the proposed
-// key is not correct
-func Decrypt(data []byte, key []byte) ([]byte, error) {
+// DecryptCFB decrypts data encrypted with the legacy AES-CFB scheme. Beware
+// that no error is detected if the key is not correct: wrong data will be
+// returned instead
+func DecryptCFB(data []byte, key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, fmt.Errorf("Decrypt cipher allocation: %v", err)
+		return nil, fmt.Errorf("DecryptCFB cipher allocation: %v", err)
 	}
 
 	if len(data) < aes.BlockSize {
-		return nil, fmt.Errorf("Decrypt: too small ciphertext, no space for the initial vector")
+		return nil, fmt.Errorf("DecryptCFB: too small ciphertext, no space for the initial vector")
 	}
 
 	iv := data[:aes.BlockSize]
@@ -66,3 +137,12 @@ func Decrypt(data []byte, key []byte) ([]byte, error) {
 
 	return destination, nil
 }
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -24,8 +24,8 @@ func TestEncryption(t *testing.T) {
 		t.Error(err)
 	}
 
-	if len(data) != (len(plainText) + aes.BlockSize) {
-		t.Errorf("Invalid initial vector allocation %v", data)
+	if len(data) != (len(plainText) + NonceSize + Overhead) {
+		t.Errorf("Invalid nonce allocation %v", data)
 	}
 }
 
@@ -54,12 +54,83 @@ func TestEncryptDecryptWrongKey(t *testing.T) {
 	}
 
 	decodedText, err := Decrypt(cipherText, []byte("this key is not so nice, even so"))
+	if err != ErrAuthenticationFailed {
+		t.Errorf("Wrong key not detected: %v", err)
+	}
+
+	if decodedText != nil {
+		t.Errorf("Uff, I decoded with a different key? %v", decodedText)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	cipherText, err := Encrypt([]byte("my good data"), testKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	cipherText[len(cipherText)-1] ^= 0x01
+	_, err = Decrypt(cipherText, testKey)
+	if err != ErrAuthenticationFailed {
+		t.Errorf("Tampering not detected: %v", err)
+	}
+}
+
+func TestEncryptDecryptWithAD(t *testing.T) {
+	plainText := []byte("my good data")
+	cipherText, err := EncryptWithAD(plainText, []byte("context"), testKey)
 	if err != nil {
 		t.Error(err)
 	}
 
-	if bytes.Equal(plainText, decodedText) {
-		t.Errorf("Uff, I decoded with a different key? %v", plainText)
+	decodedText, err := DecryptWithAD(cipherText, []byte("context"), testKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(plainText, decodedText) {
+		t.Errorf("Uff, I lost something: %v vs %v", plainText, decodedText)
+	}
+
+	_, err = DecryptWithAD(cipherText, []byte("another context"), testKey)
+	if err != ErrAuthenticationFailed {
+		t.Errorf("Wrong associated data not detected: %v", err)
+	}
+}
+
+func TestDecryptTooSmall(t *testing.T) {
+	_, err := Decrypt([]byte("short"), testKey)
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestEncryptionCFB(t *testing.T) {
+	plainText := []byte("my good data")
+	data, err := EncryptCFB(plainText, testKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(data) != (len(plainText) + aes.BlockSize) {
+		t.Errorf("Invalid initial vector allocation %v", data)
+	}
+}
+
+func TestEncryptDecryptCFB(t *testing.T) {
+	plainText := []byte("my good data")
+	cipherText, err := EncryptCFB(plainText, testKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	decodedText, err := DecryptCFB(cipherText, testKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(plainText, decodedText) {
+		t.Errorf("Uff, I lost something: %v vs %v", plainText, cipherText)
 	}
 }
 
@@ -76,3 +147,15 @@ func BenchmarkDecrypt(b *testing.B) {
 		_, _ = Decrypt(cipherText, testKey)
 	}
 }
+
+func BenchmarkDecryptValid(b *testing.B) {
+	cipherText, err := Encrypt([]byte("my good data"), testKey)
+	if err != nil {
+		b.Error(err)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, _ = Decrypt(cipherText, testKey)
+	}
+}
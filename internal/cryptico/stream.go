@@ -0,0 +1,119 @@
+package cryptico
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// StreamNoncePrefixSize is the size of the random nonce prefix used by
+	// StreamCipher
+	StreamNoncePrefixSize = 7
+)
+
+var (
+	// ErrStreamFinished is returned when a segment is sealed or opened after
+	// the last one
+	ErrStreamFinished = errors.New("stream already finished")
+
+	// ErrStreamTooLong is returned when the stream has too many segments to
+	// be encrypted with the same key
+	ErrStreamTooLong = errors.New("stream too long")
+)
+
+/*
+StreamCipher implements the STREAM construction, as described in "Online
+Authenticated-Encryption and its Nonce-Reuse Misuse-Resistance" by Hoang,
+Reyhanitabar, Rogaway and Vizár, on top of AES-256-GCM.
+
+The stream is split in segments, and each segment is encrypted with a nonce
+composed by:
+
+	+--------------+-----------------+-----------+
+	| nonce prefix | segment counter | last flag |
+	| 7            | 4 (big endian)  | 1         |
+	+--------------+-----------------+-----------+
+
+This way, reordered segments are detected since the counter will not match,
+and truncated streams are detected since the last segment is marked.
+
+Segments must be sealed and opened in order, and the same StreamCipher can't
+be used for both operations.
+*/
+type StreamCipher struct {
+	aead     cipher.AEAD
+	nonce    []byte
+	counter  uint32
+	finished bool
+}
+
+// NewStreamCipher creates a new STREAM cipher. The key must be used only for
+// one stream
+func NewStreamCipher(key []byte, noncePrefix []byte) (*StreamCipher, error) {
+	if len(noncePrefix) != StreamNoncePrefixSize {
+		return nil, fmt.Errorf("NewStreamCipher: wrong nonce prefix len: %v", len(noncePrefix))
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("NewStreamCipher cipher allocation: %v", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, noncePrefix)
+	return &StreamCipher{
+		aead:  aead,
+		nonce: nonce,
+	}, nil
+}
+
+// Seal encrypts the next segment, appending the result to dst. The last
+// segment of the stream must be marked with the `last` flag
+func (s *StreamCipher) Seal(dst []byte, segment []byte, last bool) ([]byte, error) {
+	nonce, err := s.nextNonce(last)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(dst, nonce, segment, nil), nil
+}
+
+// Open decrypts the next segment, appending the result to dst.
+// ErrAuthenticationFailed is returned if the segment has been tampered with,
+// is out of order or if the `last` flag doesn't match the one used to seal it
+func (s *StreamCipher) Open(dst []byte, segment []byte, last bool) ([]byte, error) {
+	nonce, err := s.nextNonce(last)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.aead.Open(dst, nonce, segment, nil)
+	if err != nil {
+		return nil, ErrAuthenticationFailed
+	}
+
+	return result, nil
+}
+
+// nextNonce computes the nonce for the next segment
+func (s *StreamCipher) nextNonce(last bool) ([]byte, error) {
+	if s.finished {
+		return nil, ErrStreamFinished
+	}
+
+	if s.counter == math.MaxUint32 {
+		return nil, ErrStreamTooLong
+	}
+
+	binary.BigEndian.PutUint32(s.nonce[StreamNoncePrefixSize:], s.counter)
+	if last {
+		s.nonce[len(s.nonce)-1] = 1
+		s.finished = true
+	}
+
+	s.counter++
+	return s.nonce, nil
+}
@@ -0,0 +1,94 @@
+package cryptico
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	testNoncePrefix = []byte("7 bytes")
+)
+
+func sealTestStream(t *testing.T, segments ...[]byte) [][]byte {
+	sealer, err := NewStreamCipher(testKey, testNoncePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make([][]byte, 0, len(segments))
+	for i, segment := range segments {
+		sealed, err := sealer.Seal(nil, segment, i == len(segments)-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, sealed)
+	}
+
+	return result
+}
+
+func TestStreamCipherInvalidNoncePrefix(t *testing.T) {
+	_, err := NewStreamCipher(testKey, []byte("short"))
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestStreamCipherSealOpen(t *testing.T) {
+	segments := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	sealed := sealTestStream(t, segments...)
+
+	opener, err := NewStreamCipher(testKey, testNoncePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, segment := range sealed {
+		opened, err := opener.Open(nil, segment, i == len(sealed)-1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !bytes.Equal(opened, segments[i]) {
+			t.Errorf("Uff, I lost something: %v vs %v", opened, segments[i])
+		}
+	}
+
+	_, err = opener.Open(nil, sealed[0], true)
+	if err != ErrStreamFinished {
+		t.Errorf("Segment after the last one accepted: %v", err)
+	}
+}
+
+func TestStreamCipherReordered(t *testing.T) {
+	sealed := sealTestStream(t, []byte("first"), []byte("second"), []byte("third"))
+
+	opener, err := NewStreamCipher(testKey, testNoncePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = opener.Open(nil, sealed[1], false)
+	if err != ErrAuthenticationFailed {
+		t.Errorf("Reordering not detected: %v", err)
+	}
+}
+
+func TestStreamCipherTruncated(t *testing.T) {
+	sealed := sealTestStream(t, []byte("first"), []byte("second"), []byte("third"))
+
+	opener, err := NewStreamCipher(testKey, testNoncePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = opener.Open(nil, sealed[0], false)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = opener.Open(nil, sealed[1], true)
+	if err != ErrAuthenticationFailed {
+		t.Errorf("Truncation not detected: %v", err)
+	}
+}
@@ -1,26 +1,45 @@
 /*
 Package hash implements the function relative to the one-way hashing features
-needed by the crypto engine, using the PBKDF2 key derivation function
+needed by the crypto engine, using the PBKDF2 or the Argon2id key derivation
+functions, or the HKDF one for random secrets.
+
+The hashes created by Crypt are in a legacy format, composed by the salt
+followed by the PBKDF2 derived key. The hashes created by CryptWithParams
+are encoded as PHC strings, which contain the algorithm and the parameters
+used. Check can verify both.
 */
 package hash
 
 import (
-	"bytes"
+	"crypto/subtle"
 	"errors"
 
 	"github.com/Mind-Informatica-srl/idcrypt/internal/keygen"
 	"github.com/Mind-Informatica-srl/idcrypt/internal/utils"
+	"golang.org/x/crypto/argon2"
 )
 
 const (
 	pbkdf2KeyLen = 32
 	saltLen      = 16
+
+	argon2Version = argon2.Version
 )
 
 var (
 	// ErrorInvalidHash is returned when the passed hash isn't in the right
 	// format
 	ErrorInvalidHash = errors.New("invalid hash")
+
+	// DefaultParams are the recommended parameters to be used with
+	// CryptWithParams. They are the second recommended option of RFC 9106
+	DefaultParams = keygen.Params{
+		Algorithm:   keygen.AlgorithmArgon2id,
+		Iterations:  3,
+		Memory:      64 * 1024,
+		Parallelism: 4,
+		SaltLength:  saltLen,
+	}
 )
 
 // Crypt one-way crypt the proposed data, returning it encrypted. 'Check' can
@@ -40,9 +59,51 @@ func Crypt(data []byte) ([]byte, error) {
 	return result, nil
 }
 
+// CryptWithParams one-way crypt the proposed data with the passed key
+// derivation parameters, returning the hash encoded as a PHC string.
+// 'Check' can then be used to verify if the hash is correct or not. If the
+// salt length is not specified in the parameters, 16 bytes are used
+func CryptWithParams(data []byte, params keygen.Params) ([]byte, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	length := params.SaltLength
+	if length == 0 {
+		length = saltLen
+	}
+
+	salt, err := utils.GenerateSalt(length)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := keygen.DeriveKey(data, pbkdf2KeyLen, salt, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(encodePHC(params, salt, key)), nil
+}
+
 // Check checks if the passed data corresponds to the one that was previously
-// hashed via the Hash function
+// hashed via the Crypt or the CryptWithParams functions. Data too short to be
+// hashed with HKDF doesn't correspond to any HKDF hash
 func Check(data []byte, hash []byte) (bool, error) {
+	if len(hash) > 0 && hash[0] == phcPrefix[0] {
+		params, salt, key, err := decodePHC(string(hash))
+		if err == nil {
+			providedKey, err := keygen.DeriveKey(data, len(key), salt, params)
+			if errors.Is(err, keygen.ErrorWeakSecret) {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			return subtle.ConstantTimeCompare(key, providedKey) == 1, nil
+		}
+	}
+
 	if len(hash) != (saltLen + pbkdf2KeyLen) {
 		return false, ErrorInvalidHash
 	}
@@ -50,5 +111,25 @@ func Check(data []byte, hash []byte) (bool, error) {
 	salt := hash[:saltLen]
 	key := hash[saltLen:]
 	providedKey := keygen.GenerateKey(data, pbkdf2KeyLen, salt)
-	return bytes.Equal(key, providedKey), nil
+	return subtle.ConstantTimeCompare(key, providedKey) == 1, nil
+}
+
+// ParseParams gets the key derivation parameters, including the salt length,
+// used to create the passed hash. The legacy hashes created by Crypt use
+// keygen.LegacyParams
+func ParseParams(hash []byte) (keygen.Params, error) {
+	if len(hash) > 0 && hash[0] == phcPrefix[0] {
+		params, _, _, err := decodePHC(string(hash))
+		if err == nil {
+			return params, nil
+		}
+	}
+
+	if len(hash) != (saltLen + pbkdf2KeyLen) {
+		return keygen.Params{}, ErrorInvalidHash
+	}
+
+	params := keygen.LegacyParams
+	params.SaltLength = saltLen
+	return params, nil
 }
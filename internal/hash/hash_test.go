@@ -1,7 +1,10 @@
 package hash
 
 import (
+	"bytes"
 	"testing"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/keygen"
 )
 
 var (
@@ -99,3 +102,164 @@ func BenchmarkCheck(b *testing.B) {
 		_, _ = Check(testPassword, passwordHash)
 	}
 }
+
+func TestCryptWithParamsArgon2id(t *testing.T) {
+	hash, err := CryptWithParams(testPassword, DefaultParams)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.HasPrefix(hash, []byte("$argon2id$v=19$m=65536,t=3,p=4$")) {
+		t.Errorf("This hash doesn't work: %s", hash)
+	}
+
+	res, err := Check(testPassword, hash)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !res {
+		t.Fail()
+	}
+
+	res, err = Check([]byte("anotherPassword"), hash)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if res {
+		t.Fail()
+	}
+}
+
+func TestCryptWithParamsPBKDF2(t *testing.T) {
+	hash, err := CryptWithParams(testPassword, keygen.LegacyParams)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.HasPrefix(hash, []byte("$pbkdf2-sha256$i=4096$")) {
+		t.Errorf("This hash doesn't work: %s", hash)
+	}
+
+	res, err := Check(testPassword, hash)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !res {
+		t.Fail()
+	}
+}
+
+func TestCryptWithParamsHKDF(t *testing.T) {
+	secret := []byte("a random secret of at least 256 bits")
+	params := keygen.Params{Algorithm: keygen.AlgorithmHKDFSHA256}
+	hash, err := CryptWithParams(secret, params)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.HasPrefix(hash, []byte("$hkdf-sha256$")) {
+		t.Errorf("This hash doesn't work: %s", hash)
+	}
+
+	res, err := Check(secret, hash)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !res {
+		t.Fail()
+	}
+
+	res, err = Check([]byte("another random secret of 256 bits!!"), hash)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if res {
+		t.Fail()
+	}
+
+	res, err = Check([]byte("short"), hash)
+	if err != nil || res {
+		t.Errorf("Short secret not rejected as wrong: %v %v", res, err)
+	}
+
+	if _, err = CryptWithParams(testPassword, params); err != keygen.ErrorWeakSecret {
+		t.Errorf("Short secret accepted: %v", err)
+	}
+}
+
+func TestCryptWithSaltLength(t *testing.T) {
+	params := keygen.LegacyParams
+	params.SaltLength = 32
+	hash, err := CryptWithParams(testPassword, params)
+	if err != nil {
+		t.Error(err)
+	}
+
+	parsedParams, err := ParseParams(hash)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if parsedParams != params {
+		t.Errorf("Wrong parameters: %v vs %v", parsedParams, params)
+	}
+}
+
+func TestCryptWithInvalidParams(t *testing.T) {
+	_, err := CryptWithParams(testPassword, keygen.Params{Algorithm: "md5"})
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestCheckPHCReferenceVector(t *testing.T) {
+	// Generated with Python:
+	// hashlib.pbkdf2_hmac('sha256', b'password', b'somesalt', 4096, 32)
+	hash := []byte("$pbkdf2-sha256$i=4096$c29tZXNhbHQ$LJiyvJpMLpQgW/AjCB7H5RNKMPmwnQRLxN+zohmIggU")
+	res, err := Check([]byte("password"), hash)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !res {
+		t.Fail()
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	legacyHash, err := Crypt(testPassword)
+	if err != nil {
+		t.Error(err)
+	}
+
+	params, err := ParseParams(legacyHash)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if params.Algorithm != keygen.LegacyParams.Algorithm ||
+		params.Iterations != keygen.LegacyParams.Iterations ||
+		params.SaltLength != saltLen {
+		t.Errorf("Wrong legacy parameters: %v", params)
+	}
+
+	phcHash := []byte("$argon2id$v=19$m=65536,t=3,p=4$c29tZSBzYWx0IGhlcmUhIQ$a2V5")
+	params, err = ParseParams(phcHash)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if params != DefaultParams {
+		t.Errorf("Wrong argon2id parameters: %v", params)
+	}
+
+	_, err = ParseParams([]byte("wronghashhere"))
+	if err != ErrorInvalidHash {
+		t.Fail()
+	}
+}
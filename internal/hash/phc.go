@@ -0,0 +1,144 @@
+package hash
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/keygen"
+)
+
+/*
+Hashes are encoded using the PHC string format, as described in:
+
+https://github.com/P-H-C/phc-string-format/blob/master/phc-sf-spec.md
+
+For Argon2id the encoding is the same used by the reference implementation:
+
+	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+
+and for PBKDF2-SHA256 it is:
+
+	$pbkdf2-sha256$i=4096$<salt>$<hash>
+
+HKDF-SHA256 has no parameters, so its parameters section is omitted:
+
+	$hkdf-sha256$<salt>$<hash>
+
+Salt and hash are encoded in base64 without padding.
+*/
+
+const (
+	phcPrefix = "$"
+)
+
+var (
+	phcEncoding = base64.RawStdEncoding
+)
+
+// encodePHC encodes the hash in the PHC string format
+func encodePHC(params keygen.Params, salt []byte, key []byte) string {
+	sections := []string{"", params.Algorithm}
+	switch params.Algorithm {
+	case keygen.AlgorithmArgon2id:
+		sections = append(sections, fmt.Sprintf("v=%d$m=%d,t=%d,p=%d",
+			argon2Version, params.Memory, params.Iterations, params.Parallelism))
+	case keygen.AlgorithmHKDFSHA256:
+		// No parameters section
+	default:
+		sections = append(sections, fmt.Sprintf("i=%d", params.Iterations))
+	}
+
+	sections = append(sections, phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key))
+	return strings.Join(sections, phcPrefix)
+}
+
+// decodePHC decodes an hash in the PHC string format
+func decodePHC(hash string) (params keygen.Params, salt []byte, key []byte, err error) {
+	sections := strings.Split(hash, phcPrefix)
+	if len(sections) < 4 || sections[0] != "" {
+		return params, nil, nil, ErrorInvalidHash
+	}
+
+	params.Algorithm = sections[1]
+	switch params.Algorithm {
+	case keygen.AlgorithmArgon2id:
+		if len(sections) != 6 || sections[2] != fmt.Sprintf("v=%d", argon2Version) {
+			return params, nil, nil, ErrorInvalidHash
+		}
+		err = decodePHCParams(sections[3], map[string]func(uint64){
+			"m": func(value uint64) { params.Memory = uint32(value) },
+			"t": func(value uint64) { params.Iterations = uint32(value) },
+			"p": func(value uint64) {
+				if value <= math.MaxUint8 {
+					params.Parallelism = uint8(value)
+				}
+			},
+		})
+	case keygen.AlgorithmPBKDF2SHA256:
+		if len(sections) != 5 {
+			return params, nil, nil, ErrorInvalidHash
+		}
+		err = decodePHCParams(sections[2], map[string]func(uint64){
+			"i": func(value uint64) { params.Iterations = uint32(value) },
+		})
+	case keygen.AlgorithmHKDFSHA256:
+		if len(sections) != 4 {
+			return params, nil, nil, ErrorInvalidHash
+		}
+	default:
+		return params, nil, nil, ErrorInvalidHash
+	}
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	if salt, err = phcEncoding.DecodeString(sections[len(sections)-2]); err != nil {
+		return params, nil, nil, ErrorInvalidHash
+	}
+
+	if key, err = phcEncoding.DecodeString(sections[len(sections)-1]); err != nil || len(key) == 0 {
+		return params, nil, nil, ErrorInvalidHash
+	}
+
+	params.SaltLength = len(salt)
+	if params.Validate() != nil {
+		return params, nil, nil, ErrorInvalidHash
+	}
+
+	return params, salt, key, nil
+}
+
+// decodePHCParams decodes a comma separated list of numeric parameters,
+// calling the setter associated with every one. Every parameter must be
+// present
+func decodePHCParams(section string, setters map[string]func(uint64)) error {
+	pairs := strings.Split(section, ",")
+	if len(pairs) != len(setters) {
+		return ErrorInvalidHash
+	}
+
+	for _, pair := range pairs {
+		nameValue := strings.SplitN(pair, "=", 2)
+		if len(nameValue) != 2 {
+			return ErrorInvalidHash
+		}
+
+		setter, ok := setters[nameValue[0]]
+		if !ok {
+			return ErrorInvalidHash
+		}
+
+		value, err := strconv.ParseUint(nameValue[1], 10, 32)
+		if err != nil {
+			return ErrorInvalidHash
+		}
+
+		setter(value)
+		delete(setters, nameValue[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package hash
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/keygen"
+)
+
+func TestEncodeDecodePHC(t *testing.T) {
+	salt := []byte("this is my salt!")
+	key := []byte("this is my key")
+	hkdfParams := keygen.Params{Algorithm: keygen.AlgorithmHKDFSHA256}
+	for _, params := range []keygen.Params{DefaultParams, keygen.LegacyParams, hkdfParams} {
+		params.SaltLength = len(salt)
+		encoded := encodePHC(params, salt, key)
+		decodedParams, decodedSalt, decodedKey, err := decodePHC(encoded)
+		if err != nil {
+			t.Errorf("%v: %v", encoded, err)
+		}
+
+		if decodedParams != params || !bytes.Equal(decodedSalt, salt) || !bytes.Equal(decodedKey, key) {
+			t.Errorf("Uff, I lost something: %v", encoded)
+		}
+	}
+}
+
+func TestEncodePHCFormat(t *testing.T) {
+	encoded := encodePHC(DefaultParams, []byte("salt"), []byte("key"))
+	if encoded != "$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$a2V5" {
+		t.Errorf("Wrong argon2id encoding: %v", encoded)
+	}
+
+	encoded = encodePHC(keygen.LegacyParams, []byte("salt"), []byte("key"))
+	if encoded != "$pbkdf2-sha256$i=4096$c2FsdA$a2V5" {
+		t.Errorf("Wrong pbkdf2-sha256 encoding: %v", encoded)
+	}
+
+	encoded = encodePHC(keygen.Params{Algorithm: keygen.AlgorithmHKDFSHA256}, []byte("salt"), []byte("key"))
+	if encoded != "$hkdf-sha256$c2FsdA$a2V5" {
+		t.Errorf("Wrong hkdf-sha256 encoding: %v", encoded)
+	}
+}
+
+func TestDecodeInvalidPHC(t *testing.T) {
+	invalidHashes := []string{
+		"",
+		"$",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA",
+		"$argon2id$v=16$m=65536,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=4,x=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=256$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,t=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$a2V5",
+		"$pbkdf2-sha256$i=abc$c2FsdA$a2V5",
+		"$pbkdf2-sha256$i=4096$c2FsdA$",
+		"$pbkdf2-sha256$i=4096$c2FsdA$!!!",
+		"$pbkdf2-sha256$c2FsdA$a2V5",
+		"$hkdf-sha256$i=1$c2FsdA$a2V5",
+		"$hkdf-sha256$c2FsdA",
+		"$scrypt$ln=16,r=8,p=1$c2FsdA$a2V5",
+	}
+
+	for _, hash := range invalidHashes {
+		_, _, _, err := decodePHC(hash)
+		if err != ErrorInvalidHash {
+			t.Errorf("Invalid hash accepted: %v", hash)
+		}
+	}
+}
@@ -1,20 +1,137 @@
 /*
 Package keygen implement a key generator feature, basing it on the
-PBKDF2 scheme using SHA256 hash function
+PBKDF2 scheme using SHA256 hash function or on the Argon2id scheme.
+
+Machine-generated secrets with at least 256 bits of entropy don't need a
+slow key derivation function, and can use the HKDF scheme with the SHA256
+hash function instead.
 */
 package keygen
 
 import (
 	"crypto/sha256"
+	"errors"
+	"io"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
 	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
 	pbkdf2Iterations = 4096
+
+	// AlgorithmPBKDF2SHA256 is the PBKDF2 key derivation function with the
+	// HMAC-SHA256 pseudo-random function
+	AlgorithmPBKDF2SHA256 = "pbkdf2-sha256"
+
+	// AlgorithmArgon2id is the Argon2id key derivation function, as defined
+	// in RFC 9106
+	AlgorithmArgon2id = "argon2id"
+
+	// AlgorithmHKDFSHA256 is the HKDF key derivation function with the
+	// SHA256 hash function, as defined in RFC 5869. It must only be used
+	// with random secrets of at least 256 bits
+	AlgorithmHKDFSHA256 = "hkdf-sha256"
+
+	// MinHKDFSecretLen is the minimum length in bytes of the secrets used
+	// with AlgorithmHKDFSHA256
+	MinHKDFSecretLen = 32
 )
 
+var (
+	// ErrorInvalidParams is returned when the key derivation parameters are
+	// not valid
+	ErrorInvalidParams = errors.New("invalid key derivation parameters")
+
+	// ErrorWeakSecret is returned when a secret shorter than
+	// MinHKDFSecretLen is used with AlgorithmHKDFSHA256
+	ErrorWeakSecret = errors.New("secret too short for HKDF")
+
+	// LegacyParams are the parameters used by GenerateKey
+	LegacyParams = Params{
+		Algorithm:  AlgorithmPBKDF2SHA256,
+		Iterations: pbkdf2Iterations,
+	}
+)
+
+// Params are the parameters of a key derivation function
+type Params struct {
+	// The key derivation algorithm
+	Algorithm string
+
+	// The PBKDF2 iteration count or the Argon2id time cost. Not used by HKDF
+	Iterations uint32
+
+	// The Argon2id memory cost, in KiB
+	Memory uint32
+
+	// The Argon2id degree of parallelism
+	Parallelism uint8
+
+	// The length of the salt, zero to use the default of the caller. This
+	// parameter is not used by DeriveKey, which works with the salt passed
+	SaltLength int
+}
+
+// Validate checks if the parameters can be used to derive a key
+func (params Params) Validate() error {
+	if params.SaltLength < 0 {
+		return ErrorInvalidParams
+	}
+
+	switch params.Algorithm {
+	case AlgorithmPBKDF2SHA256:
+		if params.Iterations == 0 {
+			return ErrorInvalidParams
+		}
+	case AlgorithmArgon2id:
+		if params.Iterations == 0 || params.Parallelism == 0 || params.Memory < 8*uint32(params.Parallelism) {
+			return ErrorInvalidParams
+		}
+	case AlgorithmHKDFSHA256:
+		if params.Iterations != 0 || params.Memory != 0 || params.Parallelism != 0 {
+			return ErrorInvalidParams
+		}
+	default:
+		return ErrorInvalidParams
+	}
+
+	return nil
+}
+
 // GenerateKey generate a session key for a certain password
 func GenerateKey(password []byte, keyLen int, salt []byte) []byte {
 	return pbkdf2.Key(password, salt, pbkdf2Iterations, keyLen, sha256.New)
 }
+
+// DeriveKey generate a key for a certain password using the passed key
+// derivation parameters
+func DeriveKey(password []byte, keyLen int, salt []byte, params Params) ([]byte, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch params.Algorithm {
+	case AlgorithmArgon2id:
+		return argon2.IDKey(password, salt, params.Iterations, params.Memory, params.Parallelism, uint32(keyLen)), nil
+	case AlgorithmHKDFSHA256:
+		return deriveHKDFKey(password, keyLen, salt)
+	default:
+		return pbkdf2.Key(password, salt, int(params.Iterations), keyLen, sha256.New), nil
+	}
+}
+
+// deriveHKDFKey generate a key for a random secret using HKDF-SHA256
+func deriveHKDFKey(secret []byte, keyLen int, salt []byte) ([]byte, error) {
+	if len(secret) < MinHKDFSecretLen {
+		return nil, ErrorWeakSecret
+	}
+
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, nil), key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
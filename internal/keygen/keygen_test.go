@@ -1,6 +1,7 @@
 package keygen
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/Mind-Informatica-srl/idcrypt/internal/utils"
@@ -21,3 +22,73 @@ func TestGenerateKey(t *testing.T) {
 		t.Errorf("Wrong key len: %v", len(key))
 	}
 }
+
+func TestDeriveKey(t *testing.T) {
+	password := []byte("this is a really long and strange password, it seems")
+	salt := []byte("this is my salt")
+
+	key, err := DeriveKey(password, 32, salt, LegacyParams)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(key, GenerateKey(password, 32, salt)) {
+		t.Errorf("Legacy parameters are not compatible with GenerateKey: %v", key)
+	}
+
+	key, err = DeriveKey(password, 32, salt, Params{
+		Algorithm:   AlgorithmArgon2id,
+		Iterations:  1,
+		Memory:      1024,
+		Parallelism: 1,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(key) != 32 {
+		t.Errorf("Wrong key len: %v", len(key))
+	}
+}
+
+func TestDeriveKeyHKDF(t *testing.T) {
+	secret := []byte("a random secret of at least 256 bits")
+	params := Params{Algorithm: AlgorithmHKDFSHA256}
+
+	key, err := DeriveKey(secret, 32, []byte("this is my salt"), params)
+	if err != nil {
+		t.Error(err)
+	}
+
+	otherKey, err := DeriveKey(secret, 32, []byte("this is another salt"), params)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(key) != 32 || bytes.Equal(key, otherKey) {
+		t.Errorf("Wrong keys: %v %v", key, otherKey)
+	}
+
+	if _, err = DeriveKey([]byte("password"), 32, []byte("salt"), params); err != ErrorWeakSecret {
+		t.Errorf("Short secret accepted: %v", err)
+	}
+}
+
+func TestDeriveKeyInvalidParams(t *testing.T) {
+	invalidParams := []Params{
+		{},
+		{Algorithm: "md5", Iterations: 1000},
+		{Algorithm: AlgorithmPBKDF2SHA256},
+		{Algorithm: AlgorithmArgon2id, Iterations: 1, Memory: 1024},
+		{Algorithm: AlgorithmArgon2id, Iterations: 1, Memory: 4, Parallelism: 1},
+		{Algorithm: AlgorithmPBKDF2SHA256, Iterations: 1000, SaltLength: -1},
+		{Algorithm: AlgorithmHKDFSHA256, Iterations: 1000},
+	}
+
+	for _, params := range invalidParams {
+		_, err := DeriveKey([]byte("password"), 32, []byte("salt"), params)
+		if err != ErrorInvalidParams {
+			t.Errorf("Invalid parameters accepted: %v", params)
+		}
+	}
+}
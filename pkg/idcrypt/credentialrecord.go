@@ -5,8 +5,11 @@ encryption/decryption engine.
 package idcrypt
 
 import (
+	"crypto/aes"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
 	"github.com/Mind-Informatica-srl/idcrypt/internal/hash"
@@ -14,6 +17,27 @@ import (
 	"github.com/Mind-Informatica-srl/idcrypt/internal/utils"
 )
 
+const (
+	// legacyEncryptedMasterKeyLen is the length of the master keys encrypted
+	// by older versions of this package, i.e. the CFB initialization vector
+	// followed by the 32 bytes master key
+	legacyEncryptedMasterKeyLen = aes.BlockSize + 32
+)
+
+var (
+	// MaxPasswordHistory is the number of previous password hashes kept
+	// inside a CredentialRecord by ChangePassword
+	MaxPasswordHistory = 5
+
+	// ErrPasswordReused is returned by ChangePassword when the new password
+	// is the current one or one of the previous ones
+	ErrPasswordReused = errors.New("password already used")
+
+	// ErrWeakSecret is returned by NewSecretCredentialRecord when the secret
+	// is shorter than MinSecretLength
+	ErrWeakSecret = keygen.ErrorWeakSecret
+)
+
 /*
 CredentialRecord is the record containing the information about
 the password of a certain user. There could be many passwords for
@@ -33,22 +57,62 @@ type CredentialRecord struct {
 
 	// The salt used to generate the encryption key for the master key
 	EncryptedMasterKeySalt string
+
+	// The parameters used to generate the encryption key for the master
+	// key. The zero value is used by the records created by older versions
+	// of this package, which used PBKDF2-SHA256 with 4096 iterations and a
+	// 12 bytes salt
+	MasterKeyKDF KDFParams
+
+	// The hashes of the previous passwords, hexadecimal encoded, from the
+	// most recent one. At most MaxPasswordHistory hashes are kept
+	PasswordHistory []string
+
+	// When the credential expires. This is only set for the session
+	// credentials created by NewSession, and is enforced by OpenSession
+	ExpiresAt time.Time
 }
 
 // NewCredentialRecord generates a new CredentialRecord given the passed
-// parameters, encrypting the credentials as needed
+// parameters, encrypting the credentials as needed. The key derivation
+// parameters of DefaultPolicy are used
 func NewCredentialRecord(password string, masterKey []byte) (*CredentialRecord, error) {
-	encryptedPassword, err := hash.Crypt([]byte(password))
+	return NewCredentialRecordWithPolicy(password, masterKey, DefaultPolicy)
+}
+
+// NewSecretCredentialRecord generates a new CredentialRecord for a
+// machine-generated secret, such as the shared secret of a session or an API
+// key, using the fast key derivation parameters of SecretPolicy. The secret
+// must be random, with at least 256 bits of entropy, and can't be shorter
+// than MinSecretLength. Human passwords must use NewCredentialRecord
+func NewSecretCredentialRecord(secret string, masterKey []byte) (*CredentialRecord, error) {
+	if len(secret) < MinSecretLength {
+		return nil, fmt.Errorf("NewSecretCredentialRecord: %w", ErrWeakSecret)
+	}
+
+	return NewCredentialRecordWithPolicy(secret, masterKey, SecretPolicy)
+}
+
+// NewCredentialRecordWithPolicy generates a new CredentialRecord given the
+// passed parameters, encrypting the credentials with the key derivation
+// parameters of the policy. The parameters are stored inside the record
+func NewCredentialRecordWithPolicy(password string, masterKey []byte, policy Policy) (*CredentialRecord, error) {
+	masterKeyKDF := policy.MasterKey.normalized()
+	if err := masterKeyKDF.Validate(); err != nil {
+		return nil, fmt.Errorf("NewCredentialRecord, invalid master key parameters: %v", err)
+	}
+
+	encryptedPassword, err := hash.CryptWithParams([]byte(password), policy.PasswordHash.normalized().keygenParams())
 	if err != nil {
 		return nil, fmt.Errorf("NewCredentialRecord: %v", err)
 	}
 
-	salt, err := utils.GenerateSalt(12)
+	salt, err := utils.GenerateSalt(masterKeyKDF.SaltLength)
 	if err != nil {
 		return nil, fmt.Errorf("NewCredentialRecord: %v", err)
 	}
 
-	sessionKey := keygen.GenerateKey([]byte(password), 32, salt)
+	sessionKey, err := keygen.DeriveKey([]byte(password), masterKeyEncryptionKeyLen, salt, masterKeyKDF.keygenParams())
 	if err != nil {
 		return nil, fmt.Errorf("NewCredentialRecord: %v", err)
 	}
@@ -62,23 +126,24 @@ func NewCredentialRecord(password string, masterKey []byte) (*CredentialRecord,
 		EncryptedPassword:      hex.EncodeToString(encryptedPassword),
 		EncryptedMasterKey:     hex.EncodeToString(encryptedMasterKey),
 		EncryptedMasterKeySalt: hex.EncodeToString(salt),
+		MasterKeyKDF:           masterKeyKDF,
 	}, nil
 }
 
 // IsPasswordValid check if a certain password is valid and can be used to
 // decrypt the master key
 func (credential *CredentialRecord) IsPasswordValid(password string) (bool, error) {
-	encryptedPassword, err := hex.DecodeString(credential.EncryptedPassword)
+	valid, err := checkEncodedHash(password, credential.EncryptedPassword)
 	if err != nil {
 		return false, fmt.Errorf("IsPasswordValid, invalid hash. %v", err)
 	}
 
-	return hash.Check([]byte(password), encryptedPassword)
+	return valid, nil
 }
 
 // RecoverMasterKey get the master key given the user's password, it the
-// password is valid. The user is supposed to call IsPasswordValid before
-// calling this function
+// password is valid. If the password is not valid ErrAuthenticationFailed
+// is returned.
 func (credential *CredentialRecord) RecoverMasterKey(password string) ([]byte, error) {
 	salt, err := hex.DecodeString(credential.EncryptedMasterKeySalt)
 	if err != nil {
@@ -90,11 +155,185 @@ func (credential *CredentialRecord) RecoverMasterKey(password string) ([]byte, e
 		return nil, fmt.Errorf("RecoverMasterKey, cannot decode encrypted master key: %v", err)
 	}
 
-	sessionKey := keygen.GenerateKey([]byte(password), 32, salt)
+	sessionKey, err := keygen.DeriveKey(
+		[]byte(password), masterKeyEncryptionKeyLen, salt, credential.masterKeyKDF().keygenParams())
+	if errors.Is(err, keygen.ErrorWeakSecret) {
+		// A secret too short for HKDF can't be the right one
+		return nil, fmt.Errorf("RecoverMasterKey: %w", ErrAuthenticationFailed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("RecoverMasterKey, wrong key derivation parameters: %v", err)
+	}
+
 	masterKey, err := cryptico.Decrypt(encryptedMasterKey, sessionKey)
+	if errors.Is(err, cryptico.ErrAuthenticationFailed) && credential.MasterKeyKDF == (KDFParams{}) {
+		masterKey, err = credential.recoverLegacyMasterKey(password, encryptedMasterKey, sessionKey)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("RecoverMasterKey, cannot decode master key: %v", err)
+		return nil, fmt.Errorf("RecoverMasterKey, cannot decode master key: %w", err)
 	}
 
 	return masterKey, nil
 }
+
+// recoverLegacyMasterKey decrypts a master key stored by older versions of
+// this package, which used a non-authenticated cipher. Since the cipher can't
+// tell us if the key is correct, the password hash is used instead. Only the
+// ciphertexts with the legacy layout are accepted, so a new record whose
+// MasterKeyKDF has been lost is never decrypted with the wrong cipher
+func (credential *CredentialRecord) recoverLegacyMasterKey(
+	password string, encryptedMasterKey []byte, sessionKey []byte) ([]byte, error) {
+	if len(encryptedMasterKey) != legacyEncryptedMasterKeyLen {
+		return nil, cryptico.ErrAuthenticationFailed
+	}
+
+	valid, err := credential.IsPasswordValid(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if !valid {
+		return nil, cryptico.ErrAuthenticationFailed
+	}
+
+	return cryptico.DecryptCFB(encryptedMasterKey, sessionKey)
+}
+
+// NeedsRehash check if the password hash or the parameters used to encrypt
+// the master key are weaker than the ones requested by the policy. In that
+// case the credential should be upgraded, see Login. The credentials for
+// high-entropy secrets, such as sessions, never need to be rehashed with a
+// policy for passwords, and the other way round, see KDFParams.IsWeakerThan
+func (credential *CredentialRecord) NeedsRehash(policy Policy) (bool, error) {
+	encryptedPassword, err := hex.DecodeString(credential.EncryptedPassword)
+	if err != nil {
+		return false, fmt.Errorf("NeedsRehash, invalid hash. %v", err)
+	}
+
+	passwordHashParams, err := hash.ParseParams(encryptedPassword)
+	if err != nil {
+		return false, fmt.Errorf("NeedsRehash, invalid hash. %v", err)
+	}
+
+	return kdfParamsFromKeygen(passwordHashParams).IsWeakerThan(policy.PasswordHash) ||
+		credential.masterKeyKDF().IsWeakerThan(policy.MasterKey), nil
+}
+
+// Login check if the password is valid and recovers the master key. If the
+// credential needs to be rehashed according to the policy, a new credential
+// record, which should be persisted in place of this one, is returned too.
+// The new record keeps the password history and the expiry of this one. If
+// the password is not valid ErrAuthenticationFailed is returned.
+func (credential *CredentialRecord) Login(password string, policy Policy) ([]byte, *CredentialRecord, error) {
+	valid, err := credential.IsPasswordValid(password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Login: %w", err)
+	}
+
+	if !valid {
+		return nil, nil, fmt.Errorf("Login: %w", ErrAuthenticationFailed)
+	}
+
+	masterKey, err := credential.RecoverMasterKey(password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Login: %w", err)
+	}
+
+	needsRehash, err := credential.NeedsRehash(policy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Login: %w", err)
+	}
+
+	if !needsRehash {
+		return masterKey, nil, nil
+	}
+
+	upgraded, err := NewCredentialRecordWithPolicy(password, masterKey, policy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Login: %w", err)
+	}
+
+	// The upgrade must not reset the password history, which would allow
+	// reusing the previous passwords, nor the expiry
+	upgraded.PasswordHistory = append([]string(nil), credential.PasswordHistory...)
+	upgraded.ExpiresAt = credential.ExpiresAt
+
+	return masterKey, upgraded, nil
+}
+
+// ChangePassword verifies the old password and creates a new credential
+// record, encrypting the master key with the new password and DefaultPolicy.
+// The hash of the old password is added to the password history of the new
+// record, and ErrPasswordReused is returned if the new password is the
+// current one or one of the previous ones. If the old password is not valid
+// ErrAuthenticationFailed is returned.
+func (credential *CredentialRecord) ChangePassword(oldPassword, newPassword string) (*CredentialRecord, error) {
+	return credential.ChangePasswordWithPolicy(oldPassword, newPassword, DefaultPolicy)
+}
+
+// ChangePasswordWithPolicy works like ChangePassword, encrypting the
+// credentials of the new record with the key derivation parameters of the
+// policy
+func (credential *CredentialRecord) ChangePasswordWithPolicy(
+	oldPassword, newPassword string, policy Policy) (*CredentialRecord, error) {
+	valid, err := credential.IsPasswordValid(oldPassword)
+	if err != nil {
+		return nil, fmt.Errorf("ChangePassword: %w", err)
+	}
+
+	if !valid {
+		return nil, fmt.Errorf("ChangePassword: %w", ErrAuthenticationFailed)
+	}
+
+	masterKey, err := credential.RecoverMasterKey(oldPassword)
+	if err != nil {
+		return nil, fmt.Errorf("ChangePassword: %w", err)
+	}
+
+	history := make([]string, 0, len(credential.PasswordHistory)+1)
+	history = append(history, credential.EncryptedPassword)
+	history = append(history, credential.PasswordHistory...)
+	for _, previousPassword := range history {
+		reused, err := checkEncodedHash(newPassword, previousPassword)
+		if err != nil {
+			return nil, fmt.Errorf("ChangePassword, invalid password history: %w", err)
+		}
+
+		if reused {
+			return nil, fmt.Errorf("ChangePassword: %w", ErrPasswordReused)
+		}
+	}
+
+	result, err := NewCredentialRecordWithPolicy(newPassword, masterKey, policy)
+	if err != nil {
+		return nil, fmt.Errorf("ChangePassword: %w", err)
+	}
+
+	if len(history) > MaxPasswordHistory {
+		history = history[:MaxPasswordHistory]
+	}
+	result.PasswordHistory = history
+
+	return result, nil
+}
+
+// checkEncodedHash check if the password corresponds to an hexadecimal
+// encoded hash
+func checkEncodedHash(password string, encodedHash string) (bool, error) {
+	decodedHash, err := hex.DecodeString(encodedHash)
+	if err != nil {
+		return false, err
+	}
+
+	return hash.Check([]byte(password), decodedHash)
+}
+
+// masterKeyKDF gets the parameters used to generate the encryption key for
+// the master key
+func (credential *CredentialRecord) masterKeyKDF() KDFParams {
+	if credential.MasterKeyKDF == (KDFParams{}) {
+		return legacyKDFParams
+	}
+
+	return credential.MasterKeyKDF
+}
@@ -2,7 +2,16 @@ package idcrypt
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/hash"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/keygen"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/utils"
 )
 
 var (
@@ -40,3 +49,416 @@ func TestRecoverMasterKey(t *testing.T) {
 		t.Errorf("I haven't recovered my master key. %v vs %v", key, masterKey)
 	}
 }
+
+func TestRecoverMasterKeyWrongPassword(t *testing.T) {
+	cred, err := NewCredentialRecord("this is my password", masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = cred.RecoverMasterKey("this is not my password")
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong password not detected: %v", err)
+	}
+}
+
+func TestRecoverLegacyMasterKey(t *testing.T) {
+	cred := createLegacyCredentialRecord(t, "this is my password", masterKey)
+
+	key, err := cred.RecoverMasterKey("this is my password")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(key, masterKey) {
+		t.Errorf("I haven't recovered my master key. %v vs %v", key, masterKey)
+	}
+
+	_, err = cred.RecoverMasterKey("this is not my password")
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong password not detected: %v", err)
+	}
+}
+
+func TestRecoverMasterKeyLostKDFParams(t *testing.T) {
+	cred, err := NewCredentialRecordWithPolicy("this is my password", masterKey, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A database storing only the original fields loses the parameters, and
+	// the record looks like a legacy one
+	cred.MasterKeyKDF = KDFParams{}
+
+	key, err := cred.RecoverMasterKey("this is my password")
+	if !errors.Is(err, ErrAuthenticationFailed) || key != nil {
+		t.Errorf("Master key decrypted with the legacy cipher: %v %v", key, err)
+	}
+}
+
+// createLegacyCredentialRecord creates a credential record as older versions
+// of this package did, using the non-authenticated cipher
+func createLegacyCredentialRecord(t *testing.T, password string, masterKey []byte) *CredentialRecord {
+	encryptedPassword, err := hash.Crypt([]byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	salt, err := utils.GenerateSalt(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedMasterKey, err := cryptico.EncryptCFB(masterKey, keygen.GenerateKey([]byte(password), 32, salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &CredentialRecord{
+		EncryptedPassword:      hex.EncodeToString(encryptedPassword),
+		EncryptedMasterKey:     hex.EncodeToString(encryptedMasterKey),
+		EncryptedMasterKeySalt: hex.EncodeToString(salt),
+	}
+}
+
+var (
+	testPolicy = Policy{
+		PasswordHash: KDFParams{Algorithm: KDFArgon2id, Iterations: 1, Memory: 1024, Parallelism: 1},
+		MasterKey:    KDFParams{Algorithm: KDFArgon2id, Iterations: 1, Memory: 1024, Parallelism: 1},
+	}
+)
+
+func TestCredentialRecordKDFParams(t *testing.T) {
+	policy := testPolicy
+	policy.MasterKey = KDFParams{Algorithm: KDFPBKDF2SHA256, Iterations: 1000, SaltLength: 24}
+	cred, err := NewCredentialRecordWithPolicy("this is my password", masterKey, policy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if cred.MasterKeyKDF != policy.MasterKey {
+		t.Errorf("Wrong master key parameters: %v", cred.MasterKeyKDF)
+	}
+
+	if len(cred.EncryptedMasterKeySalt) != 2*24 {
+		t.Errorf("Wrong salt length: %v", cred.EncryptedMasterKeySalt)
+	}
+
+	// The record must work even if the policy is changed
+	key, err := cred.RecoverMasterKey("this is my password")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(key, masterKey) {
+		t.Errorf("I haven't recovered my master key. %v vs %v", key, masterKey)
+	}
+}
+
+func TestCredentialRecordInvalidKDFParams(t *testing.T) {
+	policy := testPolicy
+	policy.MasterKey = KDFParams{Algorithm: KDFArgon2id}
+	_, err := NewCredentialRecordWithPolicy("this is my password", masterKey, policy)
+	if err == nil {
+		t.Error("Invalid master key parameters accepted")
+	}
+
+	policy = testPolicy
+	policy.PasswordHash = KDFParams{Algorithm: "md5"}
+	_, err = NewCredentialRecordWithPolicy("this is my password", masterKey, policy)
+	if err == nil {
+		t.Error("Invalid password hash parameters accepted")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	cred, err := NewCredentialRecordWithPolicy("this is my password", masterKey, testPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	needsRehash, err := cred.NeedsRehash(testPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if needsRehash {
+		t.Error("Credential created with the same policy needs rehash")
+	}
+
+	strongerPolicy := testPolicy
+	strongerPolicy.PasswordHash.Memory *= 2
+	needsRehash, err = cred.NeedsRehash(strongerPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !needsRehash {
+		t.Error("Weak password hash not detected")
+	}
+
+	strongerPolicy = testPolicy
+	strongerPolicy.MasterKey.SaltLength = 32
+	needsRehash, err = cred.NeedsRehash(strongerPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !needsRehash {
+		t.Error("Short master key salt not detected")
+	}
+
+	strongerPolicy = testPolicy
+	strongerPolicy.MasterKey.Iterations++
+	needsRehash, err = cred.NeedsRehash(strongerPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !needsRehash {
+		t.Error("Weak master key parameters not detected")
+	}
+}
+
+func TestNeedsRehashLegacy(t *testing.T) {
+	cred := createLegacyCredentialRecord(t, "this is my password", masterKey)
+
+	needsRehash, err := cred.NeedsRehash(testPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !needsRehash {
+		t.Error("Legacy credential doesn't need rehash")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	cred, err := NewCredentialRecordWithPolicy("this is my password", masterKey, testPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	key, upgraded, err := cred.Login("this is my password", testPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(key, masterKey) {
+		t.Errorf("I haven't recovered my master key. %v vs %v", key, masterKey)
+	}
+
+	if upgraded != nil {
+		t.Error("Credential upgraded without reason")
+	}
+
+	_, _, err = cred.Login("this is not my password", testPolicy)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong password not detected: %v", err)
+	}
+}
+
+func TestLoginUpgradesLegacy(t *testing.T) {
+	cred := createLegacyCredentialRecord(t, "this is my password", masterKey)
+
+	key, upgraded, err := cred.Login("this is my password", testPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(key, masterKey) {
+		t.Errorf("I haven't recovered my master key. %v vs %v", key, masterKey)
+	}
+
+	if upgraded == nil {
+		t.Fatal("Legacy credential not upgraded")
+	}
+
+	if upgraded.MasterKeyKDF != testPolicy.MasterKey.normalized() {
+		t.Errorf("Wrong master key parameters: %v", upgraded.MasterKeyKDF)
+	}
+
+	key, upgraded, err = upgraded.Login("this is my password", testPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(key, masterKey) {
+		t.Errorf("I haven't recovered my master key. %v vs %v", key, masterKey)
+	}
+
+	if upgraded != nil {
+		t.Error("Upgraded credential upgraded again")
+	}
+}
+
+func TestLoginUpgradeKeepsHistory(t *testing.T) {
+	weakPolicy := testPolicy
+	weakPolicy.MasterKey = KDFParams{Algorithm: KDFPBKDF2SHA256, Iterations: 1000}
+	cred, err := NewCredentialRecordWithPolicy("password 0", masterKey, weakPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred, err = cred.ChangePasswordWithPolicy("password 0", "password 1", weakPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred.ExpiresAt = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, upgraded, err := cred.Login("password 1", testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if upgraded == nil {
+		t.Fatal("Weak credential not upgraded")
+	}
+
+	if len(upgraded.PasswordHistory) != 1 || upgraded.PasswordHistory[0] != cred.PasswordHistory[0] {
+		t.Errorf("Password history lost: %v", upgraded.PasswordHistory)
+	}
+
+	if !upgraded.ExpiresAt.Equal(cred.ExpiresAt) {
+		t.Errorf("Expiry lost: %v", upgraded.ExpiresAt)
+	}
+
+	_, err = upgraded.ChangePasswordWithPolicy("password 1", "password 0", testPolicy)
+	if !errors.Is(err, ErrPasswordReused) {
+		t.Errorf("Previous password reused after the upgrade: %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	cred, err := NewCredentialRecord("this is my password", masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	changed, err := cred.ChangePassword("this is my password", "this is my new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := changed.RecoverMasterKey("this is my new password")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(key, masterKey) {
+		t.Errorf("I haven't recovered my master key. %v vs %v", key, masterKey)
+	}
+
+	if changed.EncryptedMasterKeySalt == cred.EncryptedMasterKeySalt {
+		t.Error("The salt has not been changed")
+	}
+
+	if len(changed.PasswordHistory) != 1 || changed.PasswordHistory[0] != cred.EncryptedPassword {
+		t.Errorf("Wrong password history: %v", changed.PasswordHistory)
+	}
+
+	_, err = changed.RecoverMasterKey("this is my password")
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Old password still valid: %v", err)
+	}
+}
+
+func TestChangePasswordWrongPassword(t *testing.T) {
+	cred, err := NewCredentialRecordWithPolicy("this is my password", masterKey, testPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = cred.ChangePasswordWithPolicy("this is not my password", "this is my new password", testPolicy)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong password not detected: %v", err)
+	}
+}
+
+func TestChangePasswordReused(t *testing.T) {
+	cred, err := NewCredentialRecordWithPolicy("password 0", masterKey, testPolicy)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = cred.ChangePasswordWithPolicy("password 0", "password 0", testPolicy)
+	if !errors.Is(err, ErrPasswordReused) {
+		t.Errorf("Current password reused: %v", err)
+	}
+
+	for i := 1; i <= MaxPasswordHistory+1; i++ {
+		oldPassword := fmt.Sprintf("password %v", i-1)
+		cred, err = cred.ChangePasswordWithPolicy(oldPassword, fmt.Sprintf("password %v", i), testPolicy)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(cred.PasswordHistory) != MaxPasswordHistory {
+		t.Errorf("Wrong password history len: %v", len(cred.PasswordHistory))
+	}
+
+	current := fmt.Sprintf("password %v", MaxPasswordHistory+1)
+	_, err = cred.ChangePasswordWithPolicy(current, "password 2", testPolicy)
+	if !errors.Is(err, ErrPasswordReused) {
+		t.Errorf("Previous password reused: %v", err)
+	}
+
+	// The first password is out of the history
+	_, err = cred.ChangePasswordWithPolicy(current, "password 0", testPolicy)
+	if err != nil {
+		t.Errorf("Password out of the history rejected: %v", err)
+	}
+}
+
+func TestSecretCredentialRecord(t *testing.T) {
+	secret := hex.EncodeToString([]byte("this is a random 256 bits secret"))
+	cred, err := NewSecretCredentialRecord(secret, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cred.MasterKeyKDF.Algorithm != KDFHKDFSHA256 {
+		t.Errorf("Wrong master key parameters: %v", cred.MasterKeyKDF)
+	}
+
+	key, err := cred.RecoverMasterKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(key, masterKey) {
+		t.Errorf("I haven't recovered my master key. %v vs %v", key, masterKey)
+	}
+
+	otherSecret := hex.EncodeToString([]byte("this is another 256 bits secret!"))
+	if _, err = cred.RecoverMasterKey(otherSecret); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong secret accepted: %v", err)
+	}
+
+	if needsRehash, err := cred.NeedsRehash(SecretPolicy); err != nil || needsRehash {
+		t.Errorf("Secret credential needs rehash: %v %v", needsRehash, err)
+	}
+}
+
+func TestSecretCredentialRecordWeakSecret(t *testing.T) {
+	_, err := NewSecretCredentialRecord("this is my password", masterKey)
+	if !errors.Is(err, ErrWeakSecret) {
+		t.Errorf("Weak secret accepted: %v", err)
+	}
+}
+
+func BenchmarkRecoverMasterKeySecret(b *testing.B) {
+	secret := hex.EncodeToString([]byte("this is a random 256 bits secret"))
+	cred, err := NewSecretCredentialRecord(secret, masterKey)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = cred.RecoverMasterKey(secret); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,13 +1,65 @@
 package idcrypt
 
-import "github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+import (
+	"fmt"
 
-// Encrypt encrypts data via a master key
+	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+)
+
+var (
+	// ErrAuthenticationFailed is returned when encrypted data can't be
+	// authenticated, meaning that the master key is wrong or that the data
+	// has been tampered with
+	ErrAuthenticationFailed = cryptico.ErrAuthenticationFailed
+)
+
+// Encrypt encrypts data via a master key. The result is a self-describing
+// envelope containing the information needed to decrypt it
 func Encrypt(data []byte, masterKey []byte) ([]byte, error) {
-	return cryptico.Encrypt(data, masterKey)
+	return sealEnvelope(data, nil, masterKey)
 }
 
-// Decrypt encrypts data via a master key
+// Decrypt decrypts data via a master key, returning ErrAuthenticationFailed
+// if the master key is not the one used to encrypt the data or if the data
+// has been tampered with. Data encrypted by older versions of this package,
+// without the envelope, is accepted too but, in that case, a wrong master key
+// or tampered data can't be detected. Damaged envelopes are rejected with
+// ErrInvalidEnvelope or ErrAuthenticationFailed
 func Decrypt(data []byte, masterKey []byte) ([]byte, error) {
-	return cryptico.Decrypt(data, masterKey)
+	return decryptEnvelopeOrLegacy(data, nil, masterKey)
+}
+
+// DecryptLegacy decrypts data encrypted by older versions of this package,
+// without the envelope, even when it starts by chance with the magic byte of
+// the envelopes, see IsLegacyCiphertext. The legacy scheme is not
+// authenticated, so a wrong master key or tampered data can't be detected and
+// garbage is returned: use this function only to migrate the data rejected by
+// Decrypt. Valid envelopes are rejected with ErrInvalidEnvelope
+func DecryptLegacy(data []byte, masterKey []byte) ([]byte, error) {
+	if _, err := parseEnvelope(data); err == nil {
+		return nil, fmt.Errorf("DecryptLegacy: %w", ErrInvalidEnvelope)
+	}
+
+	result, err := decryptLegacy(data, masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("DecryptLegacy: %w", err)
+	}
+
+	return result, nil
+}
+
+// EncryptWithAD encrypts data via a master key, binding it to the associated
+// data passed. The associated data, i.e. the table name, the primary key of
+// the row and the column name, is authenticated but not stored inside the
+// result, and the same one must be passed to DecryptWithAD
+func EncryptWithAD(data []byte, ad []byte, masterKey []byte) ([]byte, error) {
+	return sealEnvelope(data, ad, masterKey)
+}
+
+// DecryptWithAD decrypts data encrypted via EncryptWithAD, returning
+// ErrAuthenticationFailed if the master key or the associated data are not
+// the ones used to encrypt the data. Legacy ciphertexts are only accepted
+// when the associated data is empty
+func DecryptWithAD(data []byte, ad []byte, masterKey []byte) ([]byte, error) {
+	return decryptEnvelopeOrLegacy(data, ad, masterKey)
 }
@@ -0,0 +1,84 @@
+package idcrypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	plainText := []byte("my good data")
+	cipherText, err := Encrypt(plainText, masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	decodedText, err := Decrypt(cipherText, masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(plainText, decodedText) {
+		t.Errorf("Uff, I lost something: %v vs %v", plainText, decodedText)
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	cipherText, err := Encrypt([]byte("my good data"), masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = Decrypt(cipherText, []byte("this key is not so nice, even so"))
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong key not detected: %v", err)
+	}
+}
+
+func TestEncryptDecryptWithAD(t *testing.T) {
+	plainText := []byte("my good data")
+	ad := []byte("users/42/email")
+	cipherText, err := EncryptWithAD(plainText, ad, masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bytes.Contains(cipherText, ad) {
+		t.Error("The associated data should not be stored")
+	}
+
+	decodedText, err := DecryptWithAD(cipherText, ad, masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(plainText, decodedText) {
+		t.Errorf("Uff, I lost something: %v vs %v", plainText, decodedText)
+	}
+}
+
+func TestDecryptWithWrongAD(t *testing.T) {
+	cipherText, err := EncryptWithAD([]byte("my good data"), []byte("users/42/email"), masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = DecryptWithAD(cipherText, []byte("users/43/email"), masterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong associated data not detected: %v", err)
+	}
+
+	_, err = Decrypt(cipherText, masterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Missing associated data not detected: %v", err)
+	}
+}
+
+func TestDecryptLegacyWithAD(t *testing.T) {
+	cipherText := createLegacyCiphertext(t, []byte("my good data"), masterKey)
+
+	_, err := DecryptWithAD(cipherText, []byte("users/42/email"), masterKey)
+	if !errors.Is(err, ErrInvalidEnvelope) {
+		t.Errorf("Legacy ciphertext accepted with associated data: %v", err)
+	}
+}
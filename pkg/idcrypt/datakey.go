@@ -0,0 +1,130 @@
+package idcrypt
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"io"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/utils"
+)
+
+/*
+Every envelope created by Encrypt or NewEncryptWriter is encrypted with a
+random data key, which is stored in the envelope parameters wrapped by the
+master key:
+
+	+-------+-------------------------+-----+
+	| nonce | encrypted data key      | tag |
+	| 12    | 32                      | 16  |
+	+-------+-------------------------+-----+
+
+The data key is wrapped with AES-256-GCM, authenticating the magic byte, the
+version and the algorithm of the envelope. The same fields, together with the
+associated data, are authenticated with the payload.
+
+The key ID and the wrapped data key are not authenticated with the payload,
+so they can be replaced by RewrapDataKey without touching the payload. A
+modified key ID or wrapped data key will cause the data key to be not
+recoverable.
+*/
+
+const (
+	dataKeyLen        = 32
+	wrappedDataKeyLen = cryptico.NonceSize + dataKeyLen + cryptico.Overhead
+
+	// dataKeyADLen is the length of the header prefix authenticated together
+	// with the data key and the payload
+	dataKeyADLen = 3
+)
+
+// RewrapDataKey moves the envelope to a new master key, re-wrapping its data
+// key without decrypting the payload
+func RewrapDataKey(data []byte, oldMasterKey []byte, newMasterKey []byte) ([]byte, error) {
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return nil, fmt.Errorf("RewrapDataKey: %w", err)
+	}
+
+	header, err := rewrapEnvelopeHeader(env, oldMasterKey, newMasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("RewrapDataKey: %w", err)
+	}
+
+	result := make([]byte, 0, len(data))
+	result = append(result, header...)
+	return append(result, env.payload...), nil
+}
+
+// RewrapStreamHeader reads the header of a stream created by
+// NewEncryptWriter and returns a new header, with the data key re-wrapped
+// with the new master key. The new header has the same length of the old one,
+// and can be written in place of it without touching the rest of the stream.
+func RewrapStreamHeader(r io.Reader, oldMasterKey []byte, newMasterKey []byte) ([]byte, error) {
+	env, err := readStreamHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("RewrapStreamHeader: %w", err)
+	}
+
+	header, err := rewrapEnvelopeHeader(env, oldMasterKey, newMasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("RewrapStreamHeader: %w", err)
+	}
+
+	return header, nil
+}
+
+// newDataKeyHeader generates a new data key and creates an envelope header
+// containing it, wrapped by the master key, followed by the passed
+// parameters
+func newDataKeyHeader(algorithm byte, masterKey []byte, params []byte) ([]byte, []byte, error) {
+	dataKey, err := utils.GenerateSalt(dataKeyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, err := wrapDataKey(algorithm, dataKey, masterKey, params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dataKey, header, nil
+}
+
+// wrapDataKey creates an envelope header containing the data key, wrapped
+// by the master key, followed by the passed parameters
+func wrapDataKey(algorithm byte, dataKey []byte, masterKey []byte, params []byte) ([]byte, error) {
+	wrapAD := []byte{envelopeMagic, envelopeVersion, algorithm}
+	wrappedDataKey, err := cryptico.EncryptWithAD(dataKey, wrapAD, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newEnvelopeHeader(algorithm, masterKey, append(wrappedDataKey, params...)), nil
+}
+
+// unwrapDataKey recovers the data key of an envelope
+func unwrapDataKey(env *envelope, masterKey []byte) ([]byte, error) {
+	if !hmac.Equal(env.keyID, keyID(masterKey)) {
+		return nil, ErrAuthenticationFailed
+	}
+
+	return cryptico.DecryptWithAD(env.params[:wrappedDataKeyLen], env.header[:dataKeyADLen], masterKey)
+}
+
+// rewrapEnvelopeHeader creates a new header for the envelope, with the data
+// key wrapped by the new master key
+func rewrapEnvelopeHeader(env *envelope, oldMasterKey []byte, newMasterKey []byte) ([]byte, error) {
+	dataKey, err := unwrapDataKey(env, oldMasterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapDataKey(env.algorithm, dataKey, newMasterKey, env.params[wrappedDataKeyLen:])
+}
+
+// dataKeyAD computes the data to be authenticated together with a payload
+// encrypted with a data key
+func dataKeyAD(header []byte, ad []byte) []byte {
+	return envelopeAD(header[:dataKeyADLen], ad)
+}
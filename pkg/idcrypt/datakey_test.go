@@ -0,0 +1,119 @@
+package idcrypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+)
+
+func TestRewrapDataKey(t *testing.T) {
+	plainText := []byte("my good data")
+	cipherText, err := Encrypt(plainText, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrapped, err := RewrapDataKey(cipherText, masterKey, newTestMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rewrapped) != len(cipherText) {
+		t.Errorf("Wrong length: %v vs %v", len(rewrapped), len(cipherText))
+	}
+
+	payloadStart := envelopeFixedHeaderLen + keyIDLen + wrappedDataKeyLen
+	if !bytes.Equal(rewrapped[payloadStart:], cipherText[payloadStart:]) {
+		t.Error("Payload changed while re-wrapping the data key")
+	}
+
+	decodedText, err := Decrypt(rewrapped, newTestMasterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(decodedText, plainText) {
+		t.Errorf("Uff, I lost something: %v vs %v", plainText, decodedText)
+	}
+
+	if _, err = Decrypt(rewrapped, masterKey); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Old master key still valid: %v", err)
+	}
+}
+
+func TestRewrapDataKeyWithAD(t *testing.T) {
+	plainText := []byte("my good data")
+	ad := []byte("user 42")
+	cipherText, err := EncryptWithAD(plainText, ad, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrapped, err := RewrapDataKey(cipherText, masterKey, newTestMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodedText, err := DecryptWithAD(rewrapped, ad, newTestMasterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(decodedText, plainText) {
+		t.Errorf("Uff, I lost something: %v vs %v", plainText, decodedText)
+	}
+}
+
+func TestRewrapDataKeyWrongKey(t *testing.T) {
+	cipherText, err := Encrypt([]byte("my good data"), masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = RewrapDataKey(cipherText, newTestMasterKey, masterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong old master key not detected: %v", err)
+	}
+}
+
+func TestRewrapDataKeyTampered(t *testing.T) {
+	cipherText, err := Encrypt([]byte("my good data"), masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Modify the wrapped data key
+	cipherText[envelopeFixedHeaderLen+keyIDLen+cryptico.NonceSize] ^= 1
+	_, err = RewrapDataKey(cipherText, masterKey, newTestMasterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Tampered data key not detected: %v", err)
+	}
+}
+
+func TestRewrapStreamHeader(t *testing.T) {
+	plainText := createTestPayload(2*streamSegmentSize + 7)
+	cipherText := encryptTestStream(t, plainText)
+
+	reader := bytes.NewReader(cipherText)
+	header, err := RewrapStreamHeader(reader, masterKey, newTestMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headerLen := len(cipherText) - reader.Len()
+	if len(header) != headerLen {
+		t.Errorf("Wrong header length: %v vs %v", len(header), headerLen)
+	}
+
+	rewrapped := append(header, cipherText[headerLen:]...)
+	decodedText, err := decryptTestStream(rewrapped, newTestMasterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(decodedText, plainText) {
+		t.Error("Uff, I lost something")
+	}
+}
@@ -0,0 +1,298 @@
+package idcrypt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+)
+
+/*
+Every ciphertext produced by Encrypt is wrapped in a self-describing
+envelope, whose layout is:
+
+	+-------+---------+-----------+-------------+--------+--------+------------+
+	| magic | version | algorithm | key ID len  | key ID | params | payload    |
+	| 1     | 1       | 1         | 1           | n      |        |            |
+	+-------+---------+-----------+-------------+--------+--------+------------+
+
+The payload is composed by the nonce followed by the ciphertext. The header,
+which is everything before the payload, is authenticated together with the
+ciphertext, directly or through the wrapped data key. The optional associated
+data is authenticated too, but is not stored. The key ID is derived from the
+master key and is used to quickly detect ciphertexts encrypted with another
+master key.
+
+Data is encrypted with a random data key, which is encrypted ("wrapped")
+with the master key and stored in the algorithm-specific parameters following
+the key ID. This way, rotating the master key only requires the data key to
+be wrapped again. Look at datakey.go for the details.
+
+Streams produced by NewEncryptWriter use the same envelope, with a different
+algorithm and with the stream parameters following the key ID. Look at
+stream.go for the details.
+
+Ciphertexts produced by older versions of this package are composed only by
+the initial vector and the AES-CFB ciphertext. Those are still accepted by
+Decrypt, so databases can be migrated in place, but only when they don't
+start with the magic byte: data starting with it is always parsed as an
+envelope, so a damaged envelope is never decrypted with the legacy scheme,
+which is not authenticated. An envelope whose magic byte is damaged is
+detected too, since the rest of its header still matches the master key.
+
+A legacy ciphertext starts with the magic byte by chance once in 256 times.
+Those can only be decrypted with DecryptLegacy.
+*/
+
+const (
+	envelopeMagic   = 0xC1
+	envelopeVersion = 1
+
+	// algorithmDataKeyAES256GCM identifies ciphertexts encrypted with
+	// AES-256-GCM using a data key wrapped by the master key
+	algorithmDataKeyAES256GCM = 1
+
+	// algorithmStreamDataKeyAES256GCM identifies streams encrypted with the
+	// STREAM construction over AES-256-GCM using a data key wrapped by the
+	// master key
+	algorithmStreamDataKeyAES256GCM = 2
+
+	// envelopeFixedHeaderLen is the size of the header without the key ID
+	envelopeFixedHeaderLen = 4
+
+	keyIDLen = 8
+)
+
+var (
+	// ErrInvalidEnvelope is returned when the data can't be parsed as an
+	// encrypted envelope
+	ErrInvalidEnvelope = errors.New("invalid encrypted envelope")
+
+	keyIDLabel = []byte("idcrypt master key id")
+)
+
+// envelope is the parsed representation of an encrypted envelope
+type envelope struct {
+	// The algorithm used to encrypt the payload
+	algorithm byte
+
+	// The ID of the master key used to encrypt the payload
+	keyID []byte
+
+	// The algorithm-specific parameters following the key ID
+	params []byte
+
+	// The authenticated header
+	header []byte
+
+	// The nonce followed by the ciphertext
+	payload []byte
+}
+
+// IsLegacyCiphertext check if the passed data has been encrypted by an older
+// version of this package, without the envelope, i.e. if it doesn't start
+// with the magic byte of the envelopes. Those ciphertexts should be migrated
+// to the current format with Reencrypt or ReencryptAll
+func IsLegacyCiphertext(data []byte) bool {
+	return len(data) > 0 && data[0] != envelopeMagic
+}
+
+// keyID computes the ID of a master key to be stored in the envelope. The
+// ID is derived from the master key with HMAC-SHA256, and doesn't reveal
+// anything about the key itself
+func keyID(masterKey []byte) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	_, _ = mac.Write(keyIDLabel)
+	return mac.Sum(nil)[:keyIDLen]
+}
+
+// envelopeLayout gets the size of the algorithm-specific parameters in the
+// header and the minimum size of the payload for a certain algorithm
+func envelopeLayout(algorithm byte) (paramsLen int, minPayloadLen int, ok bool) {
+	switch algorithm {
+	case algorithmDataKeyAES256GCM:
+		return wrappedDataKeyLen, cryptico.NonceSize + cryptico.Overhead, true
+	case algorithmStreamDataKeyAES256GCM:
+		return streamDataKeyParamsLen, cryptico.Overhead, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// newEnvelopeHeader creates the header of a new envelope
+func newEnvelopeHeader(algorithm byte, masterKey []byte, params []byte) []byte {
+	header := make([]byte, envelopeFixedHeaderLen, envelopeFixedHeaderLen+keyIDLen+len(params))
+	header[0] = envelopeMagic
+	header[1] = envelopeVersion
+	header[2] = algorithm
+	header[3] = keyIDLen
+	header = append(header, keyID(masterKey)...)
+	return append(header, params...)
+}
+
+// sealEnvelope encrypts the data with a new data key, wrapped by the master
+// key, and puts the ciphertext in an envelope
+func sealEnvelope(data []byte, ad []byte, masterKey []byte) ([]byte, error) {
+	dataKey, header, err := newDataKeyHeader(algorithmDataKeyAES256GCM, masterKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := cryptico.EncryptWithAD(data, dataKeyAD(header, ad), dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header, payload...), nil
+}
+
+// openEnvelope decrypts an envelope created by sealEnvelope or by an
+// encrypting writer
+func openEnvelope(env *envelope, ad []byte, masterKey []byte) ([]byte, error) {
+	if !hmac.Equal(env.keyID, keyID(masterKey)) {
+		return nil, ErrAuthenticationFailed
+	}
+
+	switch env.algorithm {
+	case algorithmDataKeyAES256GCM:
+		dataKey, err := unwrapDataKey(env, masterKey)
+		if err != nil {
+			return nil, err
+		}
+		return cryptico.DecryptWithAD(env.payload, dataKeyAD(env.header, ad), dataKey)
+
+	case algorithmStreamDataKeyAES256GCM:
+		if len(ad) != 0 {
+			return nil, ErrAuthenticationFailed
+		}
+
+		reader, err := newDecryptReader(env, bytes.NewReader(env.payload), masterKey)
+		if err != nil {
+			return nil, err
+		}
+
+		var result bytes.Buffer
+		if _, err := result.ReadFrom(reader); err != nil {
+			return nil, err
+		}
+		return result.Bytes(), nil
+
+	default:
+		return nil, ErrInvalidEnvelope
+	}
+}
+
+// envelopeAD computes the data to be authenticated together with the
+// ciphertext. Since the header length is encoded in the header itself, the
+// concatenation is not ambiguous
+func envelopeAD(header []byte, ad []byte) []byte {
+	result := make([]byte, 0, len(header)+len(ad))
+	result = append(result, header...)
+	return append(result, ad...)
+}
+
+// envelopeHeaderLen checks the fixed part of the header, returning the full
+// header length. ErrInvalidEnvelope is returned if the data is not an
+// envelope known by this version of the package
+func envelopeHeaderLen(fixedHeader []byte) (int, error) {
+	if len(fixedHeader) < envelopeFixedHeaderLen ||
+		fixedHeader[0] != envelopeMagic ||
+		fixedHeader[1] != envelopeVersion {
+		return 0, ErrInvalidEnvelope
+	}
+
+	paramsLen, _, ok := envelopeLayout(fixedHeader[2])
+	if !ok {
+		return 0, ErrInvalidEnvelope
+	}
+
+	return envelopeFixedHeaderLen + int(fixedHeader[3]) + paramsLen, nil
+}
+
+// parseEnvelopeHeader parses a complete envelope header, whose length has
+// been computed by envelopeHeaderLen
+func parseEnvelopeHeader(header []byte) *envelope {
+	paramsLen, _, _ := envelopeLayout(header[2])
+	keyIDEnd := len(header) - paramsLen
+	return &envelope{
+		algorithm: header[2],
+		keyID:     header[envelopeFixedHeaderLen:keyIDEnd],
+		params:    header[keyIDEnd:],
+		header:    header,
+	}
+}
+
+// parseEnvelope parses an envelope, returning ErrInvalidEnvelope if the data
+// is not an envelope known by this version of the package
+func parseEnvelope(data []byte) (*envelope, error) {
+	headerLen, err := envelopeHeaderLen(data)
+	if err != nil {
+		return nil, err
+	}
+
+	_, minPayloadLen, _ := envelopeLayout(data[2])
+	if len(data) < headerLen+minPayloadLen {
+		return nil, ErrInvalidEnvelope
+	}
+
+	env := parseEnvelopeHeader(data[:headerLen])
+	env.payload = data[headerLen:]
+	return env, nil
+}
+
+// decryptEnvelopeOrLegacy decrypts an envelope or, if the data doesn't start
+// with the magic byte, a legacy ciphertext. Legacy ciphertexts have no
+// associated data, so they are rejected when the associated data is not empty
+func decryptEnvelopeOrLegacy(data []byte, ad []byte, masterKey []byte) ([]byte, error) {
+	if IsLegacyCiphertext(data) {
+		if len(ad) != 0 {
+			return nil, fmt.Errorf("Decrypt: %w", ErrInvalidEnvelope)
+		}
+
+		result, err := decryptLegacy(data, masterKey)
+		if err != nil {
+			return nil, fmt.Errorf("Decrypt: %w", err)
+		}
+
+		return result, nil
+	}
+
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt: %w", err)
+	}
+
+	result, err := openEnvelope(env, ad, masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt: %w", err)
+	}
+
+	return result, nil
+}
+
+// decryptLegacy decrypts a legacy ciphertext, rejecting the envelopes whose
+// magic byte has been damaged
+func decryptLegacy(data []byte, masterKey []byte) ([]byte, error) {
+	if isDamagedEnvelope(data, masterKey) {
+		return nil, ErrInvalidEnvelope
+	}
+
+	return cryptico.DecryptCFB(data, masterKey)
+}
+
+// isDamagedEnvelope check if the data, which doesn't start with the magic
+// byte, is an envelope encrypted with the master key whose magic byte has
+// been damaged. A legacy ciphertext passes this check by chance once in
+// 2^88 times
+func isDamagedEnvelope(data []byte, masterKey []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+
+	repaired := append([]byte{envelopeMagic}, data[1:]...)
+	env, err := parseEnvelope(repaired)
+	return err == nil && hmac.Equal(env.keyID, keyID(masterKey))
+}
@@ -0,0 +1,131 @@
+package idcrypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+)
+
+func TestEnvelopeHeader(t *testing.T) {
+	cipherText, err := Encrypt([]byte("my good data"), masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if cipherText[0] != envelopeMagic || cipherText[1] != envelopeVersion || cipherText[2] != algorithmDataKeyAES256GCM {
+		t.Errorf("Wrong envelope header: %v", cipherText[:envelopeFixedHeaderLen])
+	}
+
+	if IsLegacyCiphertext(cipherText) {
+		t.Error("Envelope detected as legacy ciphertext")
+	}
+
+	env, err := parseEnvelope(cipherText)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(env.keyID, keyID(masterKey)) {
+		t.Errorf("Wrong key ID: %v", env.keyID)
+	}
+}
+
+func TestEnvelopeTamperedHeader(t *testing.T) {
+	cipherText, err := Encrypt([]byte("my good data"), masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	cipherText[envelopeFixedHeaderLen] ^= 0x01
+	_, err = Decrypt(cipherText, masterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Tampering not detected: %v", err)
+	}
+}
+
+func TestEnvelopeTamperedFixedHeader(t *testing.T) {
+	cipherText, err := Encrypt([]byte("my good data"), masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The magic, the version and the algorithm
+	for i := 0; i < 3; i++ {
+		for _, mask := range []byte{0x01, 0x80, 0xFF} {
+			tampered := append([]byte(nil), cipherText...)
+			tampered[i] ^= mask
+
+			decodedText, err := Decrypt(tampered, masterKey)
+			if err == nil || decodedText != nil {
+				t.Errorf("Tampering of byte %v with mask %x not detected: %v", i, mask, decodedText)
+			}
+
+			if !errors.Is(err, ErrInvalidEnvelope) && !errors.Is(err, ErrAuthenticationFailed) {
+				t.Errorf("Wrong error for byte %v with mask %x: %v", i, mask, err)
+			}
+
+			if _, err = Reencrypt(tampered, masterKey, newTestMasterKey); err == nil {
+				t.Errorf("Tampering of byte %v with mask %x not detected by Reencrypt", i, mask)
+			}
+		}
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	plainText := []byte("my good data")
+	cipherText := createLegacyCiphertext(t, plainText, masterKey)
+	if !IsLegacyCiphertext(cipherText) {
+		t.Error("Legacy ciphertext not detected")
+	}
+
+	decodedText, err := Decrypt(cipherText, masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Equal(plainText, decodedText) {
+		t.Errorf("Uff, I lost something: %v vs %v", plainText, decodedText)
+	}
+
+	if _, err = DecryptWithAD(cipherText, []byte("users/1/email"), masterKey); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Errorf("Legacy ciphertext accepted with associated data: %v", err)
+	}
+
+	// Legacy ciphertexts starting with the magic byte by chance can only be
+	// decrypted explicitly
+	unlucky := append([]byte(nil), cipherText...)
+	unlucky[0] = envelopeMagic
+	if _, err = Decrypt(unlucky, masterKey); err == nil {
+		t.Error("Legacy ciphertext starting with the magic byte accepted by Decrypt")
+	}
+
+	if _, err = DecryptLegacy(unlucky, masterKey); err != nil {
+		t.Error(err)
+	}
+
+	envelope, err := Encrypt(plainText, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = DecryptLegacy(envelope, masterKey); !errors.Is(err, ErrInvalidEnvelope) {
+		t.Errorf("Envelope decrypted as legacy ciphertext: %v", err)
+	}
+}
+
+// createLegacyCiphertext encrypts data as older versions of this package
+// did, avoiding the (very rare) case of a random IV looking like an envelope
+func createLegacyCiphertext(t *testing.T, data []byte, masterKey []byte) []byte {
+	for {
+		cipherText, err := cryptico.EncryptCFB(data, masterKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cipherText[0] != envelopeMagic {
+			return cipherText
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package idcrypt
+
+import (
+	"github.com/Mind-Informatica-srl/idcrypt/internal/hash"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/keygen"
+)
+
+const (
+	// KDFPBKDF2SHA256 is the PBKDF2 key derivation function with the
+	// HMAC-SHA256 pseudo-random function
+	KDFPBKDF2SHA256 = keygen.AlgorithmPBKDF2SHA256
+
+	// KDFArgon2id is the Argon2id key derivation function
+	KDFArgon2id = keygen.AlgorithmArgon2id
+
+	// KDFHKDFSHA256 is the HKDF key derivation function with the SHA256 hash
+	// function. It is fast, and must only be used with machine-generated
+	// secrets of at least 256 bits, see SecretPolicy
+	KDFHKDFSHA256 = keygen.AlgorithmHKDFSHA256
+
+	// MinSecretLength is the minimum length in bytes of the secrets used
+	// with KDFHKDFSHA256
+	MinSecretLength = keygen.MinHKDFSecretLen
+
+	// defaultSaltLength is the salt length used when the parameters don't
+	// specify one
+	defaultSaltLength = 16
+
+	// legacySaltLength is the salt length used by the credential records
+	// created by older versions of this package
+	legacySaltLength = 12
+
+	// masterKeyEncryptionKeyLen is the length of the key encrypting the
+	// master key, which is fixed since AES-256 is used
+	masterKeyEncryptionKeyLen = 32
+)
+
+var (
+	// DefaultPolicy is the policy used by NewCredentialRecord. Every
+	// deployment can tune it to the hardware in use, since the parameters
+	// are stored inside every CredentialRecord and the existing records will
+	// continue to work
+	DefaultPolicy = Policy{
+		PasswordHash: kdfParamsFromKeygen(hash.DefaultParams),
+		MasterKey:    kdfParamsFromKeygen(hash.DefaultParams),
+	}
+
+	// SecretPolicy is the policy used by NewSecretCredentialRecord. The
+	// secrets are random, so key stretching is useless and would only slow
+	// down the recovery of the master key, which happens on every request
+	SecretPolicy = Policy{
+		PasswordHash: KDFParams{Algorithm: KDFHKDFSHA256},
+		MasterKey:    KDFParams{Algorithm: KDFHKDFSHA256},
+	}
+
+	// legacyKDFParams are the parameters used by the credential records
+	// created by older versions of this package
+	legacyKDFParams = KDFParams{
+		Algorithm:  keygen.LegacyParams.Algorithm,
+		Iterations: keygen.LegacyParams.Iterations,
+		SaltLength: legacySaltLength,
+	}
+)
+
+// KDFParams are the parameters of a key derivation function
+type KDFParams struct {
+	// The key derivation algorithm, KDFPBKDF2SHA256, KDFArgon2id or
+	// KDFHKDFSHA256
+	Algorithm string
+
+	// The PBKDF2 iteration count or the Argon2id time cost. Not used by HKDF
+	Iterations uint32
+
+	// The Argon2id memory cost, in KiB
+	Memory uint32
+
+	// The Argon2id degree of parallelism
+	Parallelism uint8
+
+	// The length of the salt in bytes, zero to use the default of 16 bytes
+	SaltLength int
+}
+
+// Policy contains the key derivation parameters to be used for new
+// credentials. Credentials created with weaker parameters should be upgraded
+// to the current policy, see CredentialRecord.NeedsRehash
+type Policy struct {
+	// The parameters used to hash the password
+	PasswordHash KDFParams
+
+	// The parameters used to derive the key encrypting the master key from
+	// the password
+	MasterKey KDFParams
+}
+
+// IsWeakerThan check if these parameters are weaker than the passed ones.
+// Parameters using a different algorithm are always considered weaker, since
+// the policy is changed. The parameters for high-entropy secrets, using
+// KDFHKDFSHA256, can't be compared with the ones for passwords and are
+// never considered weaker than them, nor the other way round
+func (params KDFParams) IsWeakerThan(other KDFParams) bool {
+	if params.isForSecrets() != other.isForSecrets() {
+		return false
+	}
+
+	if params.Algorithm != other.Algorithm {
+		return true
+	}
+
+	return params.Iterations < other.Iterations ||
+		params.Memory < other.Memory ||
+		params.Parallelism < other.Parallelism ||
+		params.normalized().SaltLength < other.normalized().SaltLength
+}
+
+// isForSecrets check if these parameters can only be used with high-entropy
+// secrets
+func (params KDFParams) isForSecrets() bool {
+	return params.Algorithm == KDFHKDFSHA256
+}
+
+// Validate checks if the parameters can be used to derive a key
+func (params KDFParams) Validate() error {
+	return params.keygenParams().Validate()
+}
+
+// normalized gets a copy of these parameters with the default values
+// applied
+func (params KDFParams) normalized() KDFParams {
+	if params.SaltLength == 0 {
+		params.SaltLength = defaultSaltLength
+	}
+
+	return params
+}
+
+// keygenParams converts these parameters to the ones used by keygen
+func (params KDFParams) keygenParams() keygen.Params {
+	return keygen.Params{
+		Algorithm:   params.Algorithm,
+		Iterations:  params.Iterations,
+		Memory:      params.Memory,
+		Parallelism: params.Parallelism,
+		SaltLength:  params.SaltLength,
+	}
+}
+
+// kdfParamsFromKeygen converts the parameters used by keygen
+func kdfParamsFromKeygen(params keygen.Params) KDFParams {
+	return KDFParams{
+		Algorithm:   params.Algorithm,
+		Iterations:  params.Iterations,
+		Memory:      params.Memory,
+		Parallelism: params.Parallelism,
+		SaltLength:  params.SaltLength,
+	}
+}
@@ -0,0 +1,33 @@
+package idcrypt
+
+import "testing"
+
+func TestIsWeakerThan(t *testing.T) {
+	params := DefaultPolicy.PasswordHash
+	if params.IsWeakerThan(params) {
+		t.Error("Parameters weaker than themselves")
+	}
+
+	if !legacyKDFParams.IsWeakerThan(params) {
+		t.Error("Algorithm change not detected")
+	}
+
+	stronger := params
+	stronger.Parallelism++
+	if !params.IsWeakerThan(stronger) {
+		t.Error("Parallelism change not detected")
+	}
+
+	if stronger.IsWeakerThan(params) {
+		t.Error("Stronger parameters detected as weaker")
+	}
+
+	// Secrets and passwords are never upgraded to each other
+	if SecretPolicy.MasterKey.IsWeakerThan(params) || params.IsWeakerThan(SecretPolicy.MasterKey) {
+		t.Error("Secret parameters compared with password ones")
+	}
+
+	if legacyKDFParams.IsWeakerThan(SecretPolicy.MasterKey) {
+		t.Error("Legacy parameters compared with secret ones")
+	}
+}
@@ -0,0 +1,229 @@
+package idcrypt
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/keygen"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/utils"
+)
+
+var (
+	// ErrWrongCryptoSpace is returned when rotating the master key of a
+	// credential which doesn't belong to the crypto space being rotated
+	ErrWrongCryptoSpace = errors.New("credential doesn't belong to this crypto space")
+)
+
+// RotationCredential is a credential to be moved to a new master key by
+// RotateMasterKey. Since the master key is encrypted with the password, the
+// password is needed to rotate the credential
+type RotationCredential struct {
+	// The credential record to be rotated
+	Record *CredentialRecord
+
+	// The password of the credential
+	Password string
+}
+
+// Blob is an encrypted value to be re-encrypted by ReencryptAll
+type Blob struct {
+	// The ID of the blob, i.e. the primary key of the row containing it
+	ID string
+
+	// The encrypted data
+	Data []byte
+
+	// The associated data used to encrypt the blob, if any
+	AD []byte
+}
+
+// BlobIterator iterates over the blobs to be re-encrypted. Next returns
+// io.EOF when there are no more blobs
+type BlobIterator interface {
+	Next() (Blob, error)
+}
+
+// ReencryptProgress is the result of ReencryptAll
+type ReencryptProgress struct {
+	// The ID of the last blob processed. If ReencryptAll is interrupted by an
+	// error, the iteration can be resumed after this blob
+	LastID string
+
+	// The number of blobs re-encrypted
+	Reencrypted int
+
+	// The number of blobs skipped since they were already encrypted with the
+	// new master key
+	Skipped int
+}
+
+// RotateMasterKey generates a new master key for a crypto space, and
+// re-encrypts the passed credentials with it. The password hashes and the
+// password history of the credentials are not changed.
+//
+// Credentials not passed to this function will still contain the old master
+// key, and the data encrypted with the old master key must be re-encrypted
+// using Reencrypt or ReencryptAll.
+func RotateMasterKey(oldMasterKey []byte, credentials []RotationCredential) ([]byte, []*CredentialRecord, error) {
+	newMasterKey, err := GenerateMasterKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("RotateMasterKey: %v", err)
+	}
+
+	result := make([]*CredentialRecord, 0, len(credentials))
+	for i, credential := range credentials {
+		masterKey, err := credential.Record.RecoverMasterKey(credential.Password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("RotateMasterKey, credential %v: %w", i, err)
+		}
+
+		if subtle.ConstantTimeCompare(masterKey, oldMasterKey) != 1 {
+			return nil, nil, fmt.Errorf("RotateMasterKey, credential %v: %w", i, ErrWrongCryptoSpace)
+		}
+
+		rotated, err := credential.Record.rewrapMasterKey(credential.Password, newMasterKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("RotateMasterKey, credential %v: %w", i, err)
+		}
+
+		result = append(result, rotated)
+	}
+
+	return newMasterKey, result, nil
+}
+
+// Reencrypt moves data to a new master key. Only the data key is re-wrapped,
+// as in RewrapDataKey, but the payload is authenticated with the old master
+// key too, to avoid re-wrapping corrupted data. This works for the streams
+// created by NewEncryptWriter too.
+//
+// Data encrypted by older versions of this package, without the envelope, is
+// decrypted with the old master key and encrypted again with the new one,
+// migrating it to the current format. As in Decrypt, a wrong old master key
+// can't be detected for that data.
+func Reencrypt(data []byte, oldMasterKey []byte, newMasterKey []byte) ([]byte, error) {
+	return ReencryptWithAD(data, nil, oldMasterKey, newMasterKey)
+}
+
+// ReencryptWithAD works like Reencrypt for data encrypted with
+// EncryptWithAD. Legacy ciphertexts, which have no associated data, are
+// bound to the passed one when migrated
+func ReencryptWithAD(data []byte, ad []byte, oldMasterKey []byte, newMasterKey []byte) ([]byte, error) {
+	if IsLegacyCiphertext(data) {
+		return reencryptLegacy(data, ad, oldMasterKey, newMasterKey)
+	}
+
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return nil, fmt.Errorf("Reencrypt: %w", err)
+	}
+
+	header, err := rewrapEnvelopeHeader(env, oldMasterKey, newMasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("Reencrypt: %w", err)
+	}
+
+	if _, err = openEnvelope(env, ad, oldMasterKey); err != nil {
+		return nil, fmt.Errorf("Reencrypt: %w", err)
+	}
+
+	return append(header, env.payload...), nil
+}
+
+// ReencryptAll re-encrypts every blob returned by the iterator, passing the
+// result to the `store` function, which should persist it.
+//
+// Blobs already encrypted with the new master key are skipped, so an
+// interrupted re-encryption can be safely resumed from the beginning or,
+// more efficiently, from the LastID of the returned progress.
+func ReencryptAll(
+	iterator BlobIterator, oldMasterKey []byte, newMasterKey []byte, store func(Blob) error) (ReencryptProgress, error) {
+	var progress ReencryptProgress
+	for {
+		blob, err := iterator.Next()
+		if err == io.EOF {
+			return progress, nil
+		}
+		if err != nil {
+			return progress, fmt.Errorf("ReencryptAll: %w", err)
+		}
+
+		if isEncryptedWith(blob.Data, newMasterKey) {
+			progress.LastID = blob.ID
+			progress.Skipped++
+			continue
+		}
+
+		data, err := ReencryptWithAD(blob.Data, blob.AD, oldMasterKey, newMasterKey)
+		if err != nil {
+			return progress, fmt.Errorf("ReencryptAll, blob %v: %w", blob.ID, err)
+		}
+
+		blob.Data = data
+		if err = store(blob); err != nil {
+			return progress, fmt.Errorf("ReencryptAll, blob %v: %w", blob.ID, err)
+		}
+
+		progress.LastID = blob.ID
+		progress.Reencrypted++
+	}
+}
+
+// reencryptLegacy migrates a legacy ciphertext to an envelope encrypted with
+// the new master key
+func reencryptLegacy(data []byte, ad []byte, oldMasterKey []byte, newMasterKey []byte) ([]byte, error) {
+	plainText, err := decryptLegacy(data, oldMasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("Reencrypt: %w", err)
+	}
+
+	result, err := sealEnvelope(plainText, ad, newMasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("Reencrypt: %v", err)
+	}
+
+	return result, nil
+}
+
+// isEncryptedWith check if the data is an envelope encrypted with the passed
+// master key, without decrypting it
+func isEncryptedWith(data []byte, masterKey []byte) bool {
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(env.keyID, keyID(masterKey)) == 1
+}
+
+// rewrapMasterKey creates a copy of this credential record with the passed
+// master key encrypted with the password, using a fresh salt
+func (credential *CredentialRecord) rewrapMasterKey(password string, masterKey []byte) (*CredentialRecord, error) {
+	masterKeyKDF := credential.masterKeyKDF()
+	salt, err := utils.GenerateSalt(masterKeyKDF.normalized().SaltLength)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionKey, err := keygen.DeriveKey(
+		[]byte(password), masterKeyEncryptionKeyLen, salt, masterKeyKDF.keygenParams())
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedMasterKey, err := cryptico.Encrypt(masterKey, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	result := *credential
+	result.EncryptedMasterKey = hex.EncodeToString(encryptedMasterKey)
+	result.EncryptedMasterKeySalt = hex.EncodeToString(salt)
+	result.MasterKeyKDF = masterKeyKDF
+	result.PasswordHistory = append([]string(nil), credential.PasswordHistory...)
+	return &result, nil
+}
@@ -0,0 +1,194 @@
+package idcrypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+var (
+	newTestMasterKey = []byte("this is my new master key, nice!")
+)
+
+// sliceIterator is a BlobIterator over a slice, which can fail at a
+// certain position
+type sliceIterator struct {
+	blobs  []Blob
+	failAt int
+	pos    int
+}
+
+func (iterator *sliceIterator) Next() (Blob, error) {
+	if iterator.pos == len(iterator.blobs) {
+		return Blob{}, io.EOF
+	}
+
+	if iterator.pos == iterator.failAt {
+		iterator.failAt = -1
+		return Blob{}, errors.New("database is gone")
+	}
+
+	iterator.pos++
+	return iterator.blobs[iterator.pos-1], nil
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	cred, err := NewCredentialRecordWithPolicy("this is my password", masterKey, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacyCred := createLegacyCredentialRecord(t, "another password", masterKey)
+
+	newMasterKey, records, err := RotateMasterKey(masterKey, []RotationCredential{
+		{Record: cred, Password: "this is my password"},
+		{Record: legacyCred, Password: "another password"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(newMasterKey, masterKey) || len(newMasterKey) != 32 {
+		t.Errorf("Wrong new master key: %v", newMasterKey)
+	}
+
+	passwords := []string{"this is my password", "another password"}
+	for i, record := range records {
+		key, err := record.RecoverMasterKey(passwords[i])
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !bytes.Equal(key, newMasterKey) {
+			t.Errorf("I haven't recovered my new master key. %v vs %v", key, newMasterKey)
+		}
+	}
+
+	if records[0].EncryptedPassword != cred.EncryptedPassword {
+		t.Error("The password hash should not change")
+	}
+}
+
+func TestRotateMasterKeyWrongCryptoSpace(t *testing.T) {
+	cred, err := NewCredentialRecordWithPolicy("this is my password", newTestMasterKey, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = RotateMasterKey(masterKey, []RotationCredential{{Record: cred, Password: "this is my password"}})
+	if !errors.Is(err, ErrWrongCryptoSpace) {
+		t.Errorf("Wrong crypto space not detected: %v", err)
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	plainText := []byte("my good data")
+	cipherTexts := [][]byte{
+		encryptTestStream(t, plainText),
+		createLegacyCiphertext(t, plainText, masterKey),
+	}
+
+	cipherText, err := Encrypt(plainText, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherTexts = append(cipherTexts, cipherText)
+
+	for _, cipherText := range cipherTexts {
+		reencrypted, err := Reencrypt(cipherText, masterKey, newTestMasterKey)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !isEncryptedWith(reencrypted, newTestMasterKey) {
+			t.Error("Data not encrypted with the new master key")
+		}
+
+		decodedText, err := Decrypt(reencrypted, newTestMasterKey)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !bytes.Equal(decodedText, plainText) {
+			t.Errorf("Uff, I lost something: %v vs %v", plainText, decodedText)
+		}
+	}
+
+	_, err = Reencrypt(cipherText, newTestMasterKey, masterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong old master key not detected: %v", err)
+	}
+}
+
+func TestReencryptStream(t *testing.T) {
+	reencrypted, err := Reencrypt(encryptTestStream(t, []byte("my good data")), masterKey, newTestMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = decryptTestStream(reencrypted, newTestMasterKey)
+	if err != nil {
+		t.Errorf("Stream not re-encrypted as a stream: %v", err)
+	}
+}
+
+func TestReencryptAll(t *testing.T) {
+	var blobs []Blob
+	for _, id := range []string{"1", "2", "3", "4"} {
+		ad := []byte("users/" + id + "/email")
+		data, err := EncryptWithAD([]byte(id+"@example.com"), ad, masterKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs = append(blobs, Blob{ID: id, Data: data, AD: ad})
+	}
+
+	// The legacy ciphertexts are migrated too, binding them to their
+	// associated data
+	blobs[0].Data = createLegacyCiphertext(t, []byte("1@example.com"), masterKey)
+	blobs[3].Data = createLegacyCiphertext(t, []byte("4@example.com"), masterKey)
+
+	store := func(blob Blob) error {
+		for i := range blobs {
+			if blobs[i].ID == blob.ID {
+				blobs[i] = blob
+			}
+		}
+		return nil
+	}
+
+	// The first run is interrupted by an error
+	iterator := &sliceIterator{blobs: blobs, failAt: 2}
+	progress, err := ReencryptAll(iterator, masterKey, newTestMasterKey, store)
+	if err == nil {
+		t.Error("Iterator error not reported")
+	}
+
+	if progress.LastID != "2" || progress.Reencrypted != 2 {
+		t.Errorf("Wrong progress: %+v", progress)
+	}
+
+	// The second run starts from the beginning, skipping the blobs already
+	// re-encrypted
+	iterator = &sliceIterator{blobs: blobs, failAt: -1}
+	progress, err = ReencryptAll(iterator, masterKey, newTestMasterKey, store)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if progress.LastID != "4" || progress.Reencrypted != 2 || progress.Skipped != 2 {
+		t.Errorf("Wrong progress: %+v", progress)
+	}
+
+	for _, blob := range blobs {
+		data, err := DecryptWithAD(blob.Data, blob.AD, newTestMasterKey)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if string(data) != blob.ID+"@example.com" {
+			t.Errorf("Uff, I lost something: %v", string(data))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package idcrypt
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/utils"
+)
+
+const (
+	// sessionIDLen is the length in bytes of the session IDs
+	sessionIDLen = 16
+
+	// sessionSecretLen is the length in bytes of the shared secrets of the
+	// sessions
+	sessionSecretLen = 32
+)
+
+var (
+	// ErrSessionExpired is returned by OpenSession when the session is
+	// expired
+	ErrSessionExpired = errors.New("session expired")
+
+	// ErrNotSession is returned by OpenSession when the credential record
+	// has not been created by NewSession
+	ErrNotSession = errors.New("credential is not a session")
+)
+
+// NewSession creates a new session, i.e. a temporary credential unlocking
+// the master key of a user. The session ID and the returned CredentialRecord
+// should be stored in the database, while the shared secret must be sent to
+// the client, see jwt.Engine.CreateSessionJWT. The session expires after
+// the passed duration.
+func NewSession(masterKey []byte, ttl time.Duration) (string, string, *CredentialRecord, error) {
+	return NewSessionAt(masterKey, ttl, time.Now())
+}
+
+// NewSessionAt works like NewSession, using the passed time as the creation
+// time of the session
+func NewSessionAt(masterKey []byte, ttl time.Duration, now time.Time) (string, string, *CredentialRecord, error) {
+	if ttl <= 0 {
+		return "", "", nil, fmt.Errorf("NewSession: invalid duration %v", ttl)
+	}
+
+	id, err := utils.GenerateSalt(sessionIDLen)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("NewSession: %v", err)
+	}
+
+	secret, err := utils.GenerateSalt(sessionSecretLen)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("NewSession: %v", err)
+	}
+
+	sharedSecret := hex.EncodeToString(secret)
+	record, err := NewSecretCredentialRecord(sharedSecret, masterKey)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("NewSession: %w", err)
+	}
+
+	record.ExpiresAt = now.Add(ttl)
+	return hex.EncodeToString(id), sharedSecret, record, nil
+}
+
+// OpenSession recovers the master key of a session given its shared secret.
+// If the session is expired ErrSessionExpired is returned, and if the shared
+// secret is not valid ErrAuthenticationFailed is returned.
+func OpenSession(record *CredentialRecord, sharedSecret string) ([]byte, error) {
+	return OpenSessionAt(record, sharedSecret, time.Now())
+}
+
+// OpenSessionAt works like OpenSession, checking the expiry of the session
+// against the passed time
+func OpenSessionAt(record *CredentialRecord, sharedSecret string, now time.Time) ([]byte, error) {
+	if record.ExpiresAt.IsZero() {
+		return nil, fmt.Errorf("OpenSession: %w", ErrNotSession)
+	}
+
+	if !now.Before(record.ExpiresAt) {
+		return nil, fmt.Errorf("OpenSession: %w", ErrSessionExpired)
+	}
+
+	// Session credentials are never created with the legacy parameters, so
+	// the weak legacy decryption must never be attempted
+	if record.MasterKeyKDF == (KDFParams{}) {
+		return nil, fmt.Errorf("OpenSession: %w", ErrNotSession)
+	}
+
+	masterKey, err := record.RecoverMasterKey(sharedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("OpenSession: %w", err)
+	}
+
+	return masterKey, nil
+}
@@ -0,0 +1,147 @@
+package idcrypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	sessionID, sharedSecret, record, err := NewSessionAt(masterKey, time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessionID) != 2*sessionIDLen || len(sharedSecret) != 2*sessionSecretLen {
+		t.Errorf("Wrong session ID or shared secret: %v %v", sessionID, sharedSecret)
+	}
+
+	if record.MasterKeyKDF.Algorithm != KDFHKDFSHA256 {
+		t.Errorf("Wrong master key parameters: %v", record.MasterKeyKDF)
+	}
+
+	if !record.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Wrong expiry: %v", record.ExpiresAt)
+	}
+
+	recoveredKey, err := OpenSessionAt(record, sharedSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(recoveredKey, masterKey) {
+		t.Error("Wrong master key recovered")
+	}
+
+	otherID, otherSecret, _, err := NewSession(masterKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if otherID == sessionID || otherSecret == sharedSecret {
+		t.Error("Session ID or shared secret reused")
+	}
+}
+
+func TestSessionWrongSecret(t *testing.T) {
+	_, _, record, err := NewSession(masterKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, otherSecret, _, err := NewSession(masterKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = OpenSession(record, otherSecret); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong shared secret accepted: %v", err)
+	}
+
+	// A secret too short for HKDF is just a wrong one
+	if _, err = OpenSession(record, "short"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Short shared secret not rejected as wrong: %v", err)
+	}
+
+	if _, _, err = record.Login("short", SecretPolicy); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Short shared secret not rejected by Login: %v", err)
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	_, sharedSecret, record, err := NewSessionAt(masterKey, time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = OpenSessionAt(record, sharedSecret, now.Add(time.Hour-time.Second)); err != nil {
+		t.Errorf("Valid session rejected: %v", err)
+	}
+
+	if _, err = OpenSessionAt(record, sharedSecret, now.Add(time.Hour)); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Expired session accepted: %v", err)
+	}
+
+	// The sessions created without an explicit time expire after the
+	// duration from now
+	if _, sharedSecret, record, err = NewSession(masterKey, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenSession(record, sharedSecret); err != nil {
+		t.Errorf("Valid session rejected: %v", err)
+	}
+
+	if _, _, _, err = NewSession(masterKey, 0); err == nil {
+		t.Error("Session without duration created")
+	}
+}
+
+func TestOpenSessionWithUserCredential(t *testing.T) {
+	record, err := NewCredentialRecord("this is my password", masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = OpenSession(record, "this is my password"); !errors.Is(err, ErrNotSession) {
+		t.Errorf("User credential accepted as session: %v", err)
+	}
+}
+
+func TestSessionNotUpgradedByLogin(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	_, sharedSecret, record, err := NewSessionAt(masterKey, time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if needsRehash, err := record.NeedsRehash(DefaultPolicy); err != nil || needsRehash {
+		t.Errorf("Session needs rehash with a password policy: %v %v", needsRehash, err)
+	}
+
+	// Upgrading the session to a password policy would lose its expiry
+	recoveredKey, upgraded, err := record.Login(sharedSecret, DefaultPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if upgraded != nil {
+		t.Errorf("Session upgraded to a password policy: %v", upgraded.MasterKeyKDF)
+	}
+
+	if !bytes.Equal(recoveredKey, masterKey) {
+		t.Error("Wrong master key recovered")
+	}
+
+	// Passwords are not downgraded to the secret policy either
+	passwordRecord, err := NewCredentialRecordWithPolicy("this is my password", masterKey, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if needsRehash, err := passwordRecord.NeedsRehash(SecretPolicy); err != nil || needsRehash {
+		t.Errorf("Password needs rehash with the secret policy: %v %v", needsRehash, err)
+	}
+}
@@ -0,0 +1,288 @@
+package idcrypt
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/utils"
+)
+
+/*
+Streams are encrypted using the STREAM construction implemented in
+cryptico.StreamCipher. The envelope header is followed by the stream
+parameters:
+
+	+------------------+--------------+
+	| wrapped data key | nonce prefix |
+	| 60               | 7            |
+	+------------------+--------------+
+
+The stream is encrypted with the data key, which is random for every stream.
+Look at datakey.go for the details about the data key.
+
+The plaintext is split in segments of streamSegmentSize bytes, each one
+followed by its authentication tag. Only the last segment can be shorter.
+*/
+
+const (
+	streamDataKeyParamsLen = wrappedDataKeyLen + cryptico.StreamNoncePrefixSize
+	streamSegmentSize      = 64 * 1024
+)
+
+var (
+	// ErrWriterClosed is returned when writing on a closed encrypting writer
+	ErrWriterClosed = errors.New("write on closed encrypting writer")
+)
+
+// encryptWriter is the writer returned by NewEncryptWriter
+type encryptWriter struct {
+	destination io.Writer
+	cipher      *cryptico.StreamCipher
+
+	// The header still to be written, nil if already written
+	header []byte
+
+	// The plaintext of the current segment
+	buffer []byte
+
+	// The ciphertext of the current segment
+	sealed []byte
+
+	err error
+}
+
+// NewEncryptWriter creates a writer which encrypts everything written to it
+// with the master key, writing the result to `w`. Every segment of the
+// stream is authenticated, so truncated, reordered or modified streams are
+// detected by NewDecryptReader.
+//
+// The writer must be closed to write the last segment. Closing it doesn't
+// close `w`.
+func NewEncryptWriter(w io.Writer, masterKey []byte) (io.WriteCloser, error) {
+	noncePrefix, err := utils.GenerateSalt(cryptico.StreamNoncePrefixSize)
+	if err != nil {
+		return nil, fmt.Errorf("NewEncryptWriter: %v", err)
+	}
+
+	dataKey, header, err := newDataKeyHeader(algorithmStreamDataKeyAES256GCM, masterKey, noncePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("NewEncryptWriter: %v", err)
+	}
+
+	streamCipher, err := cryptico.NewStreamCipher(dataKey, noncePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("NewEncryptWriter: %v", err)
+	}
+
+	return &encryptWriter{
+		destination: w,
+		cipher:      streamCipher,
+		header:      header,
+		buffer:      make([]byte, 0, streamSegmentSize),
+		sealed:      make([]byte, 0, streamSegmentSize+cryptico.Overhead),
+	}, nil
+}
+
+// Write encrypts the data, writing every complete segment to the underlying
+// writer
+func (writer *encryptWriter) Write(data []byte) (int, error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+
+	written := 0
+	for len(data) > 0 {
+		// The current segment is sealed only when we know that it's not the
+		// last one
+		if len(writer.buffer) == streamSegmentSize {
+			if err := writer.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(writer.buffer[len(writer.buffer):streamSegmentSize], data)
+		writer.buffer = writer.buffer[:len(writer.buffer)+n]
+		data = data[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close writes the last segment of the stream
+func (writer *encryptWriter) Close() error {
+	if writer.err != nil {
+		if writer.err == ErrWriterClosed {
+			return nil
+		}
+		return writer.err
+	}
+
+	if err := writer.flush(true); err != nil {
+		return err
+	}
+
+	writer.err = ErrWriterClosed
+	return nil
+}
+
+// flush seals the current segment and writes it
+func (writer *encryptWriter) flush(last bool) error {
+	if writer.header != nil {
+		if _, err := writer.destination.Write(writer.header); err != nil {
+			writer.err = err
+			return err
+		}
+		writer.header = nil
+	}
+
+	sealed, err := writer.cipher.Seal(writer.sealed[:0], writer.buffer, last)
+	if err != nil {
+		writer.err = err
+		return err
+	}
+
+	if _, err = writer.destination.Write(sealed); err != nil {
+		writer.err = err
+		return err
+	}
+
+	writer.buffer = writer.buffer[:0]
+	return nil
+}
+
+// decryptReader is the reader returned by NewDecryptReader
+type decryptReader struct {
+	source *bufio.Reader
+	cipher *cryptico.StreamCipher
+
+	// The ciphertext of the current segment
+	segment []byte
+
+	// The decrypted data not yet read
+	plain []byte
+
+	finished bool
+	err      error
+}
+
+// NewDecryptReader creates a reader which decrypts a stream created by
+// NewEncryptWriter. ErrAuthenticationFailed is returned, by this function or
+// while reading, if the master key is wrong or if the stream has been
+// truncated, reordered or modified.
+//
+// Since every segment is returned as soon as it is authenticated, the data
+// read before an error must be discarded.
+func NewDecryptReader(r io.Reader, masterKey []byte) (io.Reader, error) {
+	env, err := readStreamHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("NewDecryptReader: %w", err)
+	}
+
+	if !hmac.Equal(env.keyID, keyID(masterKey)) {
+		return nil, fmt.Errorf("NewDecryptReader: %w", ErrAuthenticationFailed)
+	}
+
+	return newDecryptReader(env, r, masterKey)
+}
+
+// readStreamHeader reads and parses the envelope header at the start of a
+// stream
+func readStreamHeader(r io.Reader) (*envelope, error) {
+	fixedHeader := make([]byte, envelopeFixedHeaderLen)
+	if _, err := io.ReadFull(r, fixedHeader); err != nil {
+		return nil, fmt.Errorf("cannot read header: %v", err)
+	}
+
+	headerLen, err := envelopeHeaderLen(fixedHeader)
+	if err != nil || fixedHeader[2] != algorithmStreamDataKeyAES256GCM {
+		return nil, ErrInvalidEnvelope
+	}
+
+	header := make([]byte, headerLen)
+	copy(header, fixedHeader)
+	if _, err = io.ReadFull(r, header[envelopeFixedHeaderLen:]); err != nil {
+		return nil, fmt.Errorf("cannot read header: %v", err)
+	}
+
+	return parseEnvelopeHeader(header), nil
+}
+
+// newDecryptReader creates a reader for the stream segments, following the
+// already parsed header
+func newDecryptReader(env *envelope, r io.Reader, masterKey []byte) (*decryptReader, error) {
+	streamCipher, err := newStreamCipher(env, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		source:  bufio.NewReader(r),
+		cipher:  streamCipher,
+		segment: make([]byte, streamSegmentSize+cryptico.Overhead),
+	}, nil
+}
+
+// Read reads the decrypted data
+func (reader *decryptReader) Read(data []byte) (int, error) {
+	for len(reader.plain) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
+
+		if reader.finished {
+			return 0, io.EOF
+		}
+
+		reader.err = reader.readSegment()
+	}
+
+	n := copy(data, reader.plain)
+	reader.plain = reader.plain[n:]
+	return n, nil
+}
+
+// readSegment reads and decrypts the next segment
+func (reader *decryptReader) readSegment() error {
+	n, err := io.ReadFull(reader.source, reader.segment)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err == io.EOF:
+		// The last segment is missing
+		return ErrAuthenticationFailed
+	case err != nil:
+		return err
+	default:
+		if _, err = reader.source.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plain, err := reader.cipher.Open(reader.segment[:0], reader.segment[:n], last)
+	if err != nil {
+		return err
+	}
+
+	reader.plain = plain
+	reader.finished = last
+	return nil
+}
+
+// newStreamCipher creates the STREAM cipher for a certain envelope,
+// recovering the data key with the master key
+func newStreamCipher(env *envelope, masterKey []byte) (*cryptico.StreamCipher, error) {
+	dataKey, err := unwrapDataKey(env, masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cryptico.NewStreamCipher(dataKey, env.params[wrappedDataKeyLen:])
+}
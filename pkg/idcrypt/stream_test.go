@@ -0,0 +1,188 @@
+package idcrypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func createTestPayload(size int) []byte {
+	result := make([]byte, size)
+	for i := range result {
+		result[i] = byte(i % 251)
+	}
+	return result
+}
+
+func encryptTestStream(t *testing.T, plainText []byte) []byte {
+	var buffer bytes.Buffer
+	writer, err := NewEncryptWriter(&buffer, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write in small chunks to exercise the segment buffering
+	for len(plainText) > 0 {
+		n := 1000
+		if n > len(plainText) {
+			n = len(plainText)
+		}
+		if _, err = writer.Write(plainText[:n]); err != nil {
+			t.Fatal(err)
+		}
+		plainText = plainText[n:]
+	}
+
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func decryptTestStream(cipherText []byte, key []byte) ([]byte, error) {
+	reader, err := NewDecryptReader(bytes.NewReader(cipherText), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(reader)
+}
+
+func TestStreamEncryptDecrypt(t *testing.T) {
+	sizes := []int{0, 1, streamSegmentSize - 1, streamSegmentSize, streamSegmentSize + 1, 3*streamSegmentSize + 5}
+	for _, size := range sizes {
+		plainText := createTestPayload(size)
+		cipherText := encryptTestStream(t, plainText)
+
+		decodedText, err := decryptTestStream(cipherText, masterKey)
+		if err != nil {
+			t.Errorf("Size %v: %v", size, err)
+		}
+
+		if !bytes.Equal(plainText, decodedText) {
+			t.Errorf("Size %v: uff, I lost something", size)
+		}
+
+		decodedText, err = Decrypt(cipherText, masterKey)
+		if err != nil {
+			t.Errorf("Size %v: %v", size, err)
+		}
+
+		if !bytes.Equal(plainText, decodedText) {
+			t.Errorf("Size %v: uff, I lost something with Decrypt", size)
+		}
+	}
+}
+
+func TestStreamWrongKey(t *testing.T) {
+	cipherText := encryptTestStream(t, createTestPayload(100))
+
+	_, err := decryptTestStream(cipherText, []byte("this key is not so nice, even so"))
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Wrong key not detected: %v", err)
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	cipherText := encryptTestStream(t, createTestPayload(2*streamSegmentSize+10))
+	headerLen, err := envelopeHeaderLen(cipherText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the last segment
+	truncated := cipherText[:headerLen+2*(streamSegmentSize+16)]
+	_, err = decryptTestStream(truncated, masterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Truncation not detected: %v", err)
+	}
+
+	// Drop everything but the header
+	_, err = decryptTestStream(cipherText[:headerLen], masterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Truncation not detected: %v", err)
+	}
+}
+
+func TestStreamReordered(t *testing.T) {
+	cipherText := encryptTestStream(t, createTestPayload(2*streamSegmentSize+10))
+	headerLen, err := envelopeHeaderLen(cipherText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segmentLen := streamSegmentSize + 16
+	first := cipherText[headerLen : headerLen+segmentLen]
+	second := cipherText[headerLen+segmentLen : headerLen+2*segmentLen]
+
+	var reordered []byte
+	reordered = append(reordered, cipherText[:headerLen]...)
+	reordered = append(reordered, second...)
+	reordered = append(reordered, first...)
+	reordered = append(reordered, cipherText[headerLen+2*segmentLen:]...)
+
+	_, err = decryptTestStream(reordered, masterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Reordering not detected: %v", err)
+	}
+}
+
+func TestStreamTampered(t *testing.T) {
+	cipherText := encryptTestStream(t, createTestPayload(100))
+
+	// Tamper with the salt in the header
+	tampered := append([]byte{}, cipherText...)
+	tampered[envelopeFixedHeaderLen+keyIDLen] ^= 0x01
+	_, err := decryptTestStream(tampered, masterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Header tampering not detected: %v", err)
+	}
+
+	// Tamper with the ciphertext
+	tampered = append([]byte{}, cipherText...)
+	tampered[len(tampered)-20] ^= 0x01
+	_, err = decryptTestStream(tampered, masterKey)
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("Tampering not detected: %v", err)
+	}
+}
+
+func TestStreamNotAStream(t *testing.T) {
+	cipherText, err := Encrypt([]byte("my good data"), masterKey)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = decryptTestStream(cipherText, masterKey)
+	if !errors.Is(err, ErrInvalidEnvelope) {
+		t.Errorf("Envelope accepted as a stream: %v", err)
+	}
+}
+
+func TestStreamWriteAfterClose(t *testing.T) {
+	writer, err := NewEncryptWriter(ioutil.Discard, masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writer.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = writer.Write([]byte("data")); err != ErrWriterClosed {
+		t.Errorf("Write after close accepted: %v", err)
+	}
+}
+
+func BenchmarkStreamEncrypt(b *testing.B) {
+	plainText := createTestPayload(1024 * 1024)
+	b.SetBytes(int64(len(plainText)))
+	for n := 0; n < b.N; n++ {
+		writer, _ := NewEncryptWriter(ioutil.Discard, masterKey)
+		_, _ = io.Copy(writer, bytes.NewReader(plainText))
+		_ = writer.Close()
+	}
+}
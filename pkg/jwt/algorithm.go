@@ -0,0 +1,149 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// AlgorithmRS256 is RSASSA-PKCS1-v1_5 with SHA-256
+	AlgorithmRS256 = "RS256"
+
+	// AlgorithmRS512 is RSASSA-PKCS1-v1_5 with SHA-512, the default one
+	AlgorithmRS512 = "RS512"
+
+	// AlgorithmES256 is ECDSA with the P-256 curve and SHA-256
+	AlgorithmES256 = "ES256"
+
+	// AlgorithmES384 is ECDSA with the P-384 curve and SHA-384
+	AlgorithmES384 = "ES384"
+
+	// AlgorithmES512 is ECDSA with the P-521 curve and SHA-512
+	AlgorithmES512 = "ES512"
+
+	// AlgorithmEdDSA is EdDSA with Ed25519 keys
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	// ErrUnsupportedAlgorithm is returned when creating an engine with a
+	// signing algorithm not supported by this package
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+
+	signingMethods = map[string]jwt.SigningMethod{
+		AlgorithmRS256: jwt.SigningMethodRS256,
+		AlgorithmRS512: jwt.SigningMethodRS512,
+		AlgorithmES256: jwt.SigningMethodES256,
+		AlgorithmES384: jwt.SigningMethodES384,
+		AlgorithmES512: jwt.SigningMethodES512,
+		AlgorithmEdDSA: SigningMethodEdDSA,
+	}
+)
+
+// signingMethod gets the signing method of an algorithm, nil if the
+// algorithm is not supported
+func signingMethod(algorithm string) jwt.SigningMethod {
+	return signingMethods[algorithm]
+}
+
+// parseKeyPair decodes a key pair encoded in PEM format, checking that the
+// keys can be used with the algorithm
+func parseKeyPair(
+	algorithm string, privateKeyBytes []byte, publicKeyBytes []byte) (crypto.PrivateKey, crypto.PublicKey, error) {
+	privateKey, err := parsePrivateKey(algorithm, privateKeyBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding private key: %v", err)
+	}
+
+	publicKey, err := parsePublicKey(algorithm, publicKeyBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding public key: %v", err)
+	}
+
+	return privateKey, publicKey, nil
+}
+
+// parsePrivateKey decodes a private key encoded in PEM format
+func parsePrivateKey(algorithm string, keyBytes []byte) (crypto.PrivateKey, error) {
+	switch algorithm {
+	case AlgorithmRS256, AlgorithmRS512:
+		return jwt.ParseRSAPrivateKeyFromPEM(keyBytes)
+
+	case AlgorithmES256, AlgorithmES384, AlgorithmES512:
+		key, err := jwt.ParseECPrivateKeyFromPEM(keyBytes)
+		if err != nil {
+			return nil, err
+		}
+		return key, checkCurve(algorithm, &key.PublicKey)
+
+	case AlgorithmEdDSA:
+		block, _ := pem.Decode(keyBytes)
+		if block == nil {
+			return nil, jwt.ErrKeyMustBePEMEncoded
+		}
+
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := key.(ed25519.PrivateKey); !ok {
+			return nil, jwt.ErrInvalidKeyType
+		}
+		return key, nil
+
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// parsePublicKey decodes a public key encoded in PEM format
+func parsePublicKey(algorithm string, keyBytes []byte) (crypto.PublicKey, error) {
+	switch algorithm {
+	case AlgorithmRS256, AlgorithmRS512:
+		return jwt.ParseRSAPublicKeyFromPEM(keyBytes)
+
+	case AlgorithmES256, AlgorithmES384, AlgorithmES512:
+		key, err := jwt.ParseECPublicKeyFromPEM(keyBytes)
+		if err != nil {
+			return nil, err
+		}
+		return key, checkCurve(algorithm, key)
+
+	case AlgorithmEdDSA:
+		block, _ := pem.Decode(keyBytes)
+		if block == nil {
+			return nil, jwt.ErrKeyMustBePEMEncoded
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := key.(ed25519.PublicKey); !ok {
+			return nil, jwt.ErrInvalidKeyType
+		}
+		return key, nil
+
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// checkCurve check if an ECDSA key uses the curve required by the algorithm
+func checkCurve(algorithm string, key *ecdsa.PublicKey) error {
+	method := signingMethod(algorithm).(*jwt.SigningMethodECDSA)
+	if key.Curve.Params().BitSize != method.CurveBits {
+		return fmt.Errorf("%v requires a %v bits curve", algorithm, method.CurveBits)
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var testKeyFiles = map[string]string{
+	AlgorithmRS256: "signing",
+	AlgorithmRS512: "signing",
+	AlgorithmES256: "es256",
+	AlgorithmES384: "es384",
+	AlgorithmES512: "es512",
+	AlgorithmEdDSA: "ed25519",
+}
+
+func createTestEngineWithAlgorithm(algorithm string, keyFile string) (*Engine, error) {
+	privateKey, err := ioutil.ReadFile("testdata/" + keyFile + ".key")
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := ioutil.ReadFile("testdata/" + keyFile + ".pub")
+	if err != nil {
+		return nil, err
+	}
+
+	return CreateEngineWithAlgorithm(algorithm, privateKey, publicKey, time.Hour*24)
+}
+
+func TestAlgorithms(t *testing.T) {
+	for algorithm, keyFile := range testKeyFiles {
+		engine, err := createTestEngineWithAlgorithm(algorithm, keyFile)
+		if err != nil {
+			t.Errorf("%v: cannot create engine: %v", algorithm, err)
+			continue
+		}
+
+		tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+		if err != nil {
+			t.Errorf("%v: cannot create token: %v", algorithm, err)
+			continue
+		}
+
+		token, _, err := new(jwt.Parser).ParseUnverified(tokenString, &CustomClaims{})
+		if err != nil {
+			t.Error(err)
+		} else if token.Header["alg"] != algorithm {
+			t.Errorf("%v: wrong algorithm in header: %v", algorithm, token.Header["alg"])
+		}
+
+		claims, err := engine.ParseJWT(tokenString)
+		if err != nil {
+			t.Errorf("%v: cannot decode token: %v", algorithm, err)
+			continue
+		}
+
+		if claims.SharedSecret != "mygreatpassword" {
+			t.Errorf("%v: shared secret isn't preserved: %v", algorithm, claims.SharedSecret)
+		}
+	}
+}
+
+func TestEngineKeyFields(t *testing.T) {
+	rsaEngine, err := createTestEngineWithAlgorithm(AlgorithmRS512, testKeyFiles[AlgorithmRS512])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rsaEngine.PrivateKey == nil || rsaEngine.PublicKey == nil {
+		t.Error("RSA keys not stored in the RSA fields")
+	}
+
+	if rsaEngine.SigningKey != nil || rsaEngine.VerificationKey != nil {
+		t.Error("RSA keys stored in the generic fields")
+	}
+
+	// An engine configured only with the RSA fields still works
+	engine := &Engine{
+		NowFunc:       time.Now,
+		PrivateKey:    rsaEngine.PrivateKey,
+		PublicKey:     rsaEngine.PublicKey,
+		TokenDuration: time.Hour,
+	}
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = rsaEngine.ParseJWT(tokenString); err != nil {
+		t.Error(err)
+	}
+
+	ecEngine, err := createTestEngineWithAlgorithm(AlgorithmES256, testKeyFiles[AlgorithmES256])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ecEngine.PrivateKey != nil || ecEngine.PublicKey != nil {
+		t.Error("ECDSA keys stored in the RSA fields")
+	}
+
+	if ecEngine.SigningKey == nil || ecEngine.VerificationKey == nil {
+		t.Error("ECDSA keys not stored")
+	}
+}
+
+func TestEngineKeyRotation(t *testing.T) {
+	engine, err := createTestEngineWithAlgorithm(AlgorithmRS512, testKeyFiles[AlgorithmRS512])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldEngine := *engine
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing the RSA keys after the creation of the engine is honoured
+	engine.PrivateKey = newKey
+	engine.PublicKey = &newKey.PublicKey
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = engine.ParseJWT(tokenString); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = oldEngine.ParseJWT(tokenString); err == nil {
+		t.Error("Token signed with the old key")
+	}
+
+	// The same key can be set in both fields, but different ones are rejected
+	engine.SigningKey = newKey
+	engine.VerificationKey = &newKey.PublicKey
+	if tokenString, err = engine.CreateJWT("myself", "mygreatpassword"); err != nil {
+		t.Fatal(err)
+	}
+
+	engine.SigningKey = oldEngine.PrivateKey
+	if _, err = engine.CreateJWT("myself", "mygreatpassword"); !errors.Is(err, ErrConflictingKeys) {
+		t.Errorf("Conflicting signing keys accepted: %v", err)
+	}
+
+	engine.VerificationKey = oldEngine.PublicKey
+	if _, err = engine.ParseJWT(tokenString); err == nil {
+		t.Error("Conflicting verification keys accepted")
+	}
+
+	if _, err = engine.JWKS(); !errors.Is(err, ErrConflictingKeys) {
+		t.Errorf("Conflicting verification keys published: %v", err)
+	}
+}
+
+func TestAlgorithmPinning(t *testing.T) {
+	engines := make(map[string]*Engine)
+	for algorithm, keyFile := range testKeyFiles {
+		engine, err := createTestEngineWithAlgorithm(algorithm, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		engines[algorithm] = engine
+	}
+
+	for signingAlgorithm, signingEngine := range engines {
+		tokenString, err := signingEngine.CreateJWT("myself", "mygreatpassword")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for parsingAlgorithm, parsingEngine := range engines {
+			if parsingAlgorithm == signingAlgorithm {
+				continue
+			}
+
+			if _, err = parsingEngine.ParseJWT(tokenString); err == nil {
+				t.Errorf("%v token accepted by %v engine", signingAlgorithm, parsingAlgorithm)
+			}
+		}
+	}
+}
+
+func TestAlgorithmNone(t *testing.T) {
+	engine, err := createTestEngineWithAlgorithm(AlgorithmEdDSA, "ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, engine.CreateCustomClaims("myself", "mygreatpassword"))
+	tokenString, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = engine.ParseJWT(tokenString); err == nil {
+		t.Error("Unsigned token accepted")
+	}
+}
+
+func TestCreateEngineWrongCurve(t *testing.T) {
+	_, err := createTestEngineWithAlgorithm(AlgorithmES256, "es384")
+	if err == nil {
+		t.Error("P-384 key accepted for ES256")
+	}
+}
+
+func TestCreateEngineWrongKeyType(t *testing.T) {
+	_, err := createTestEngineWithAlgorithm(AlgorithmEdDSA, "es256")
+	if err == nil {
+		t.Error("ECDSA key accepted for EdDSA")
+	}
+
+	_, err = createTestEngineWithAlgorithm(AlgorithmES256, "signing")
+	if err == nil {
+		t.Error("RSA key accepted for ES256")
+	}
+}
+
+func TestCreateEngineUnsupportedAlgorithm(t *testing.T) {
+	_, err := createTestEngineWithAlgorithm("HS256", "signing")
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Unsupported algorithm not detected: %v", err)
+	}
+}
+
+func TestEdDSATampered(t *testing.T) {
+	engine, err := createTestEngineWithAlgorithm(AlgorithmEdDSA, "ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the first character of the signature
+	signatureStart := strings.LastIndex(tokenString, ".") + 1
+	replacement := "A"
+	if tokenString[signatureStart] == 'A' {
+		replacement = "B"
+	}
+
+	tokenString = tokenString[:signatureStart] + replacement + tokenString[signatureStart+1:]
+	if _, err = engine.ParseJWT(tokenString); err == nil {
+		t.Error("Tampered signature not detected")
+	}
+}
+
+func BenchmarkGenerateTokenES256(b *testing.B) {
+	engine, err := createTestEngineWithAlgorithm(AlgorithmES256, "es256")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, _ = engine.CreateJWT("myself", "mygreatpassword")
+	}
+}
+
+func BenchmarkGenerateTokenEdDSA(b *testing.B) {
+	engine, err := createTestEngineWithAlgorithm(AlgorithmEdDSA, "ed25519")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, _ = engine.CreateJWT("myself", "mygreatpassword")
+	}
+}
@@ -0,0 +1,71 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+const (
+	tokenIDLen = 16
+)
+
+var (
+	// ErrTokenExpired is returned when parsing an expired token, or a
+	// token without expiration
+	ErrTokenExpired = errors.New("token is expired")
+
+	// ErrTokenNotValidYet is returned when parsing a token before its `nbf`
+	// claim
+	ErrTokenNotValidYet = errors.New("token is not valid yet")
+
+	// ErrTokenIssuedInFuture is returned when parsing a token whose `iat`
+	// claim is in the future
+	ErrTokenIssuedInFuture = errors.New("token issued in the future")
+
+	// ErrWrongIssuer is returned when parsing a token with the wrong issuer
+	ErrWrongIssuer = errors.New("token has wrong issuer")
+
+	// ErrWrongAudience is returned when parsing a token with the wrong
+	// audience
+	ErrWrongAudience = errors.New("token has wrong audience")
+)
+
+// validateClaims check the time-based claims using NowFunc and the leeway
+// of the engine, and the issuer and the audience, if configured
+func (e *Engine) validateClaims(claims *CustomClaims) error {
+	now := e.NowFunc().Unix()
+	leeway := int64(e.Leeway.Seconds())
+
+	if claims.ExpiresAt == 0 || now-leeway > claims.ExpiresAt {
+		return ErrTokenExpired
+	}
+
+	if claims.NotBefore != 0 && now+leeway < claims.NotBefore {
+		return ErrTokenNotValidYet
+	}
+
+	if claims.IssuedAt != 0 && now+leeway < claims.IssuedAt {
+		return ErrTokenIssuedInFuture
+	}
+
+	if e.Issuer != "" && claims.Issuer != e.Issuer {
+		return ErrWrongIssuer
+	}
+
+	if e.Audience != "" && claims.Audience != e.Audience {
+		return ErrWrongAudience
+	}
+
+	return nil
+}
+
+// newTokenID generates a random token ID
+func newTokenID() (string, error) {
+	id := make([]byte, tokenIDLen)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
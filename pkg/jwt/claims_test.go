@@ -0,0 +1,152 @@
+package jwt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func createTestClaimsEngine(t *testing.T, now *time.Time) *Engine {
+	engine, err := createTestEngineWithAlgorithm(AlgorithmEdDSA, "ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine.Issuer = "https://auth.example.com"
+	engine.Audience = "documents"
+	engine.TokenDuration = time.Hour
+	engine.NowFunc = func() time.Time {
+		return *now
+	}
+	return engine
+}
+
+func TestStandardClaims(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine := createTestClaimsEngine(t, &now)
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := engine.ParseJWT(tokenString)
+	if err != nil {
+		t.Fatal("Cannot decode token", err)
+	}
+
+	if claims.Issuer != engine.Issuer || claims.Audience != engine.Audience {
+		t.Errorf("Wrong issuer or audience: %v %v", claims.Issuer, claims.Audience)
+	}
+
+	if claims.IssuedAt != now.Unix() || claims.NotBefore != now.Unix() {
+		t.Errorf("Wrong iat or nbf: %v %v", claims.IssuedAt, claims.NotBefore)
+	}
+
+	if claims.ExpiresAt != now.Add(time.Hour).Unix() {
+		t.Errorf("Wrong exp: %v", claims.ExpiresAt)
+	}
+
+	if len(claims.Id) < 16 {
+		t.Errorf("Wrong jti: %v", claims.Id)
+	}
+
+	otherToken, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherClaims, err := engine.ParseJWT(otherToken)
+	if err != nil {
+		t.Fatal("Cannot decode token", err)
+	}
+
+	if otherClaims.Id == claims.Id {
+		t.Errorf("Token ID reused: %v", claims.Id)
+	}
+}
+
+func TestWrongIssuerAndAudience(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine := createTestClaimsEngine(t, &now)
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine.Audience = "payments"
+	if _, err = engine.ParseJWT(tokenString); !errors.Is(err, ErrWrongAudience) {
+		t.Errorf("Wrong audience not detected: %v", err)
+	}
+
+	engine.Audience = "documents"
+	engine.Issuer = "https://evil.example.com"
+	if _, err = engine.ParseJWT(tokenString); !errors.Is(err, ErrWrongIssuer) {
+		t.Errorf("Wrong issuer not detected: %v", err)
+	}
+
+	// Engines without issuer and audience accept every token
+	engine.Issuer = ""
+	engine.Audience = ""
+	if _, err = engine.ParseJWT(tokenString); err != nil {
+		t.Error("Cannot decode token", err)
+	}
+}
+
+func TestTimeClaimsWithLeeway(t *testing.T) {
+	issued := time.Unix(1600000000, 0)
+	now := issued
+	engine := createTestClaimsEngine(t, &now)
+	engine.Leeway = time.Minute
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		now      time.Time
+		expected error
+	}{
+		{issued.Add(-2 * time.Minute), ErrTokenNotValidYet},
+		{issued.Add(-30 * time.Second), nil},
+		{issued.Add(time.Hour + 30*time.Second), nil},
+		{issued.Add(time.Hour + 2*time.Minute), ErrTokenExpired},
+	}
+
+	for _, test := range tests {
+		now = test.now
+		_, err = engine.ParseJWT(tokenString)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("At %v expected %v, got %v", test.now, test.expected, err)
+		}
+	}
+
+	engine.Leeway = 0
+	now = issued.Add(-30 * time.Second)
+	if _, err = engine.ParseJWT(tokenString); !errors.Is(err, ErrTokenNotValidYet) {
+		t.Errorf("Token accepted before nbf: %v", err)
+	}
+
+	now = issued.Add(time.Hour + 30*time.Second)
+	if _, err = engine.ParseJWT(tokenString); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expired token accepted: %v", err)
+	}
+}
+
+func TestTokenWithoutExpiration(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine := createTestClaimsEngine(t, &now)
+
+	claims := engine.CreateCustomClaims("myself", "mygreatpassword")
+	claims.ExpiresAt = 0
+	tokenString, err := engine.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = engine.ParseJWT(tokenString); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Token without expiration accepted: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrEdDSAVerification is returned when an EdDSA signature is not valid
+	ErrEdDSAVerification = errors.New("ed25519: verification error")
+
+	// SigningMethodEdDSA is the EdDSA signing method (RFC 8037), which is
+	// missing from the JWT library. Only Ed25519 keys are supported
+	SigningMethodEdDSA = &signingMethodEdDSA{}
+)
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// signingMethodEdDSA implements the EdDSA signing method. It expects an
+// ed25519.PrivateKey for signing and an ed25519.PublicKey for verification
+type signingMethodEdDSA struct{}
+
+// Alg gets the algorithm identifier of this signing method
+func (m *signingMethodEdDSA) Alg() string {
+	return AlgorithmEdDSA
+}
+
+// Verify check if the signature of the signing string is valid
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return ErrEdDSAVerification
+	}
+
+	return nil
+}
+
+// Sign signs the signing string, returning the encoded signature
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
@@ -0,0 +1,168 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" // #nosec G505, SHA-1 is required by RSA-OAEP as defined by RFC 7518
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+/*
+Encrypted tokens are nested JWTs (RFC 7519, section 5.2): the signed token is
+encrypted as a compact JWE (RFC 7516) whose header is:
+
+	{"alg":"RSA-OAEP","enc":"A256GCM","cty":"JWT"}
+
+The content encryption key is random for every token and is encrypted with
+the RSA public key of the engine using RSA-OAEP. The signed token is then
+encrypted with AES-256-GCM, authenticating the encoded JWE header.
+*/
+
+const (
+	jweAlgorithm   = "RSA-OAEP"
+	jweEncryption  = "A256GCM"
+	jweContentType = "JWT"
+	jweParts       = 5
+	jweKeyLen      = 32
+)
+
+var (
+	// ErrInvalidJWE is returned when an encrypted token is malformed or can't
+	// be decrypted
+	ErrInvalidJWE = errors.New("invalid encrypted token")
+
+	// ErrNoDecryptionKey is returned when parsing an encrypted token with an
+	// engine without the decryption key
+	ErrNoDecryptionKey = errors.New("encrypted token but no decryption key")
+)
+
+// jweHeader is the protected header of an encrypted token
+type jweHeader struct {
+	Algorithm   string `json:"alg"`
+	Encryption  string `json:"enc"`
+	ContentType string `json:"cty,omitempty"`
+}
+
+// SetEncryptionKeys enables the encryption of the tokens with a key pair
+// encoded in PEM format. Once enabled, CreateJWT emits encrypted tokens, so
+// that the shared secret can't be read by the ones handling the token. The
+// key pair should be different from the one used to sign the tokens.
+//
+// ParseJWT accepts both encrypted and signed-only tokens, so the tokens
+// created before enabling the encryption will continue to work until they
+// expire.
+func (e *Engine) SetEncryptionKeys(privateKeyBytes []byte, publicKeyBytes []byte) error {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyBytes)
+	if err != nil {
+		return fmt.Errorf("SetEncryptionKeys, error decoding private key: %v", err)
+	}
+
+	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyBytes)
+	if err != nil {
+		return fmt.Errorf("SetEncryptionKeys, error decoding public key: %v", err)
+	}
+
+	e.EncryptionPrivateKey = privateKey
+	e.EncryptionPublicKey = publicKey
+	return nil
+}
+
+// IsEncryptedJWT check if a token is encrypted, without decrypting it
+func IsEncryptedJWT(tokenString string) bool {
+	return strings.Count(tokenString, ".") == jweParts-1
+}
+
+// encryptJWT encrypts a signed token as a compact JWE
+func encryptJWT(signedToken string, publicKey *rsa.PublicKey) (string, error) {
+	header, err := json.Marshal(jweHeader{
+		Algorithm:   jweAlgorithm,
+		Encryption:  jweEncryption,
+		ContentType: jweContentType,
+	})
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+
+	contentKey := make([]byte, jweKeyLen)
+	if _, err = rand.Read(contentKey); err != nil {
+		return "", err
+	}
+
+	encryptedKey, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, publicKey, contentKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// The result is composed by the nonce, the ciphertext and the tag, which
+	// are the last three parts of the JWE
+	sealed, err := cryptico.EncryptWithAD([]byte(signedToken), []byte(encodedHeader), contentKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := sealed[:cryptico.NonceSize]
+	cipherText := sealed[cryptico.NonceSize : len(sealed)-cryptico.Overhead]
+	tag := sealed[len(sealed)-cryptico.Overhead:]
+
+	return strings.Join([]string{
+		encodedHeader,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(nonce),
+		base64.RawURLEncoding.EncodeToString(cipherText),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// decryptJWT decrypts a compact JWE created by encryptJWT, returning the
+// nested signed token
+func decryptJWT(tokenString string, privateKey *rsa.PrivateKey) (string, error) {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != jweParts {
+		return "", ErrInvalidJWE
+	}
+
+	decoded := make([][]byte, jweParts)
+	for i, part := range parts {
+		var err error
+		if decoded[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return "", ErrInvalidJWE
+		}
+	}
+
+	var header jweHeader
+	if err := json.Unmarshal(decoded[0], &header); err != nil {
+		return "", ErrInvalidJWE
+	}
+
+	// The algorithms are pinned, as for the signed tokens
+	if header.Algorithm != jweAlgorithm || header.Encryption != jweEncryption || header.ContentType != jweContentType {
+		return "", fmt.Errorf("encrypted token has wrong algorithm")
+	}
+
+	if len(decoded[2]) != cryptico.NonceSize || len(decoded[4]) != cryptico.Overhead {
+		return "", ErrInvalidJWE
+	}
+
+	contentKey, err := rsa.DecryptOAEP(sha1.New(), nil, privateKey, decoded[1], nil)
+	if err != nil || len(contentKey) != jweKeyLen {
+		return "", ErrInvalidJWE
+	}
+
+	sealed := make([]byte, 0, len(decoded[2])+len(decoded[3])+len(decoded[4]))
+	sealed = append(sealed, decoded[2]...)
+	sealed = append(sealed, decoded[3]...)
+	sealed = append(sealed, decoded[4]...)
+	signedToken, err := cryptico.DecryptWithAD(sealed, []byte(parts[0]), contentKey)
+	if err != nil {
+		return "", ErrInvalidJWE
+	}
+
+	return string(signedToken), nil
+}
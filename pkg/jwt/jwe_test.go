@@ -0,0 +1,200 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func createTestEncryptingEngine() (*Engine, error) {
+	engine, err := createTestEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := ioutil.ReadFile("testdata/encryption.key")
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := ioutil.ReadFile("testdata/encryption.pub")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = engine.SetEncryptionKeys(privateKey, publicKey); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+func TestParseEncryptedJWT(t *testing.T) {
+	engine, err := createTestEncryptingEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal("Cannot create token", err)
+	}
+
+	if !IsEncryptedJWT(tokenString) {
+		t.Errorf("Token not encrypted: %v", tokenString)
+	}
+
+	claims, err := engine.ParseJWT(tokenString)
+	if err != nil {
+		t.Fatal("Cannot decode token", err)
+	}
+
+	if claims.SharedSecret != "mygreatpassword" {
+		t.Errorf("Shared secret isn't preserved: %v", claims.SharedSecret)
+	}
+
+	if claims.Subject != "myself" {
+		t.Errorf("Subject isn't preserved: %v", claims.Subject)
+	}
+}
+
+func TestEncryptedJWTHidesSharedSecret(t *testing.T) {
+	engine, err := createTestEncryptingEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal("Cannot create token", err)
+	}
+
+	for _, part := range strings.Split(tokenString, ".") {
+		decoded, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if strings.Contains(string(decoded), "mygreatpassword") {
+			t.Errorf("Shared secret visible in the token: %v", tokenString)
+		}
+	}
+
+	header, _ := base64.RawURLEncoding.DecodeString(strings.Split(tokenString, ".")[0])
+	if string(header) != `{"alg":"RSA-OAEP","enc":"A256GCM","cty":"JWT"}` {
+		t.Errorf("Wrong header: %v", string(header))
+	}
+}
+
+func TestParseSignedJWTWithEncryptingEngine(t *testing.T) {
+	signingEngine, err := createTestEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := createTestEncryptingEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := signingEngine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal("Cannot create token", err)
+	}
+
+	claims, err := engine.ParseJWT(tokenString)
+	if err != nil {
+		t.Fatal("Cannot decode token", err)
+	}
+
+	if claims.SharedSecret != "mygreatpassword" {
+		t.Errorf("Shared secret isn't preserved: %v", claims.SharedSecret)
+	}
+}
+
+func TestParseEncryptedJWTWithoutKey(t *testing.T) {
+	engine, err := createTestEncryptingEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal("Cannot create token", err)
+	}
+
+	signingEngine, err := createTestEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = signingEngine.ParseJWT(tokenString)
+	if !errors.Is(err, ErrNoDecryptionKey) {
+		t.Errorf("Missing decryption key not detected: %v", err)
+	}
+}
+
+func TestParseEncryptedJWTTampered(t *testing.T) {
+	engine, err := createTestEncryptingEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal("Cannot create token", err)
+	}
+
+	parts := strings.Split(tokenString, ".")
+	for i := range parts {
+		tampered := make([]string, len(parts))
+		copy(tampered, parts)
+
+		decoded, err := base64.RawURLEncoding.DecodeString(tampered[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded[0] ^= 1
+		tampered[i] = base64.RawURLEncoding.EncodeToString(decoded)
+
+		if _, err = engine.ParseJWT(strings.Join(tampered, ".")); err == nil {
+			t.Errorf("Tampered part %v not detected", i)
+		}
+	}
+}
+
+func TestParseEncryptedJWTWrongAlgorithm(t *testing.T) {
+	engine, err := createTestEncryptingEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal("Cannot create token", err)
+	}
+
+	parts := strings.Split(tokenString, ".")
+	parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RSA1_5","enc":"A256GCM","cty":"JWT"}`))
+	if _, err = engine.ParseJWT(strings.Join(parts, ".")); err == nil {
+		t.Error("Wrong algorithm not detected")
+	}
+}
+
+func BenchmarkParseEncryptedJWT(b *testing.B) {
+	engine, err := createTestEncryptingEngine()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_, _ = engine.ParseJWT(tokenString)
+	}
+}
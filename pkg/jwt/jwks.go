@@ -0,0 +1,353 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+/*
+The verification keys can be published as a JSON Web Key Set (RFC 7517), so
+that other services can verify the tokens without having the PEM files. RSA
+keys are encoded as described in RFC 7518, ECDSA keys too and Ed25519 keys
+as described in RFC 8037.
+
+Every key in the set carries its ID, which is matched with the `kid` header
+of the tokens, and its algorithm, which is pinned when verifying them. The
+ID of the public key of an engine, which is not part of a keyring, is its
+thumbprint as defined by RFC 7638.
+*/
+
+const (
+	jwkUseSignature = "sig"
+	jwkTypeRSA      = "RSA"
+	jwkTypeEC       = "EC"
+	jwkTypeOKP      = "OKP"
+	jwkCurveEd25519 = "Ed25519"
+
+	// minRSAKeyBits is the minimum size of the RSA keys loaded from a JWKS
+	minRSAKeyBits = 2048
+)
+
+var (
+	// ErrInvalidJWKS is returned when a JSON Web Key Set can't be decoded
+	ErrInvalidJWKS = errors.New("invalid JSON Web Key Set")
+)
+
+// jsonWebKey is a key in a JSON Web Key Set
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// jsonWebKeySet is a JSON Web Key Set
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// JWKS gets the verification keys of this engine as a JSON Web Key Set. If
+// the engine has a keyring every key is included, retired keys too, since
+// they are still used to verify the tokens. The public key of the engine is
+// included too, with its thumbprint as ID.
+func (e *Engine) JWKS() ([]byte, error) {
+	keySet := jsonWebKeySet{
+		Keys: []jsonWebKey{},
+	}
+
+	publicKey, err := e.verificationKey()
+	if err != nil {
+		return nil, fmt.Errorf("JWKS: %w", err)
+	}
+
+	if publicKey != nil {
+		algorithm := e.Algorithm
+		if algorithm == "" {
+			algorithm = AlgorithmRS512
+		}
+
+		jwk, err := encodeJWK("", algorithm, publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS: %v", err)
+		}
+		jwk.KeyID = jwkThumbprint(jwk)
+		keySet.Keys = append(keySet.Keys, jwk)
+	}
+
+	if e.Keyring != nil {
+		for _, key := range e.Keyring.Keys() {
+			jwk, err := encodeJWK(key.ID, key.Algorithm, key.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("JWKS, key %v: %v", key.ID, err)
+			}
+			keySet.Keys = append(keySet.Keys, jwk)
+		}
+	}
+
+	return json.Marshal(keySet)
+}
+
+// JWKSHandler gets an HTTP handler serving the JSON Web Key Set of this
+// engine. The set is computed on every request, so it reflects the changes
+// made to the keyring
+func (e *Engine) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		jwks, err := e.JWKS()
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		_, _ = w.Write(jwks)
+	})
+}
+
+// CreateVerifierEngine create a new JWT engine which only verifies the
+// tokens, using the keys of a JSON Web Key Set. Only the keys with an ID and
+// a supported algorithm are used, the other ones are ignored.
+func CreateVerifierEngine(jwks []byte) (*Engine, error) {
+	keys, err := parseJWKS(jwks)
+	if err != nil {
+		return nil, fmt.Errorf("CreateVerifierEngine: %w", err)
+	}
+
+	keyring := NewKeyring()
+	keyring.replace(keys)
+	return CreateEngineWithKeyring(keyring, 0), nil
+}
+
+// parseJWKS decodes the keys of a JSON Web Key Set
+func parseJWKS(data []byte) ([]*Key, error) {
+	var keySet jsonWebKeySet
+	if err := json.Unmarshal(data, &keySet); err != nil || keySet.Keys == nil {
+		return nil, ErrInvalidJWKS
+	}
+
+	result := make([]*Key, 0, len(keySet.Keys))
+	for _, jwk := range keySet.Keys {
+		if jwk.KeyID == "" || (jwk.Use != "" && jwk.Use != jwkUseSignature) || signingMethod(jwk.Algorithm) == nil {
+			continue
+		}
+
+		publicKey, err := decodeJWK(jwk)
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %w", jwk.KeyID, err)
+		}
+
+		result = append(result, &Key{
+			ID:        jwk.KeyID,
+			Algorithm: jwk.Algorithm,
+			PublicKey: publicKey,
+		})
+	}
+
+	return result, nil
+}
+
+// encodeJWK encodes a public key as a JSON Web Key
+func encodeJWK(id string, algorithm string, publicKey crypto.PublicKey) (jsonWebKey, error) {
+	jwk := jsonWebKey{
+		Use:       jwkUseSignature,
+		KeyID:     id,
+		Algorithm: algorithm,
+	}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = jwkTypeRSA
+		jwk.N = encodeJWKBytes(key.N.Bytes())
+		jwk.E = encodeJWKBytes(big.NewInt(int64(key.E)).Bytes())
+
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = jwkTypeEC
+		jwk.Curve = key.Curve.Params().Name
+		jwk.X = encodeJWKBytes(padJWKInt(key.X.Bytes(), size))
+		jwk.Y = encodeJWKBytes(padJWKInt(key.Y.Bytes(), size))
+
+	case ed25519.PublicKey:
+		jwk.KeyType = jwkTypeOKP
+		jwk.Curve = jwkCurveEd25519
+		jwk.X = encodeJWKBytes(key)
+
+	default:
+		return jwk, jwt.ErrInvalidKeyType
+	}
+
+	return jwk, nil
+}
+
+// decodeJWK decodes the public key of a JSON Web Key, checking that it can
+// be used with its algorithm
+func decodeJWK(jwk jsonWebKey) (crypto.PublicKey, error) {
+	switch jwk.Algorithm {
+	case AlgorithmRS256, AlgorithmRS512:
+		return decodeRSAJWK(jwk)
+
+	case AlgorithmES256, AlgorithmES384, AlgorithmES512:
+		key, err := decodeECJWK(jwk)
+		if err != nil {
+			return nil, err
+		}
+		return key, checkCurve(jwk.Algorithm, key)
+
+	case AlgorithmEdDSA:
+		if jwk.KeyType != jwkTypeOKP || jwk.Curve != jwkCurveEd25519 {
+			return nil, ErrInvalidJWKS
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrInvalidJWKS
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// decodeRSAJWK decodes an RSA public key
+func decodeRSAJWK(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	if jwk.KeyType != jwkTypeRSA {
+		return nil, ErrInvalidJWKS
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, ErrInvalidJWKS
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, ErrInvalidJWKS
+	}
+
+	key := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+
+	if key.N.BitLen() < minRSAKeyBits || key.E < 3 || key.E%2 == 0 {
+		return nil, ErrInvalidJWKS
+	}
+
+	return key, nil
+}
+
+// decodeECJWK decodes an ECDSA public key
+func decodeECJWK(jwk jsonWebKey) (*ecdsa.PublicKey, error) {
+	if jwk.KeyType != jwkTypeEC {
+		return nil, ErrInvalidJWKS
+	}
+
+	var curve elliptic.Curve
+	switch jwk.Curve {
+	case elliptic.P256().Params().Name:
+		curve = elliptic.P256()
+	case elliptic.P384().Params().Name:
+		curve = elliptic.P384()
+	case elliptic.P521().Params().Name:
+		curve = elliptic.P521()
+	default:
+		return nil, ErrInvalidJWKS
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil || len(x) != size {
+		return nil, ErrInvalidJWKS
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil || len(y) != size {
+		return nil, ErrInvalidJWKS
+	}
+
+	key := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+
+	if !curve.IsOnCurve(key.X, key.Y) {
+		return nil, ErrInvalidJWKS
+	}
+
+	return key, nil
+}
+
+// verificationKeyID gets the ID of the public key of this engine, which is
+// its RFC 7638 thumbprint. An empty ID is returned if the engine has no
+// public key or if its type is not supported
+func (e *Engine) verificationKeyID() string {
+	publicKey, err := e.verificationKey()
+	if err != nil || publicKey == nil {
+		return ""
+	}
+
+	jwk, err := encodeJWK("", "", publicKey)
+	if err != nil {
+		return ""
+	}
+
+	return jwkThumbprint(jwk)
+}
+
+// jwkThumbprint computes the thumbprint of a JSON Web Key as defined by
+// RFC 7638: the SHA-256 hash of the required members, in lexicographic order
+// and without whitespace, base64url encoded
+func jwkThumbprint(jwk jsonWebKey) string {
+	var members string
+	switch jwk.KeyType {
+	case jwkTypeRSA:
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk.E, jwk.KeyType, jwk.N)
+	case jwkTypeEC:
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk.Curve, jwk.KeyType, jwk.X, jwk.Y)
+	default:
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk.Curve, jwk.KeyType, jwk.X)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return encodeJWKBytes(sum[:])
+}
+
+// encodeJWKBytes encodes a key parameter as base64url
+func encodeJWKBytes(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// padJWKInt left-pads a big-endian integer to the passed size
+func padJWKInt(data []byte, size int) []byte {
+	if len(data) >= size {
+		return data
+	}
+
+	result := make([]byte, size)
+	copy(result[size-len(data):], data)
+	return result
+}
@@ -0,0 +1,383 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func createTestJWKSKeyring(t *testing.T) *Keyring {
+	keyring := NewKeyring()
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmES256, AlgorithmES384, AlgorithmES512, AlgorithmEdDSA} {
+		if err := keyring.Add(createTestKey(t, "key-"+algorithm, algorithm)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keyring
+}
+
+func TestJWKS(t *testing.T) {
+	engine, err := createTestEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Keyring = createTestJWKSKeyring(t)
+
+	jwks, err := engine.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keySet struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err = json.Unmarshal(jwks, &keySet); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keySet.Keys) != 6 {
+		t.Fatalf("Wrong number of keys: %v", len(keySet.Keys))
+	}
+
+	expected := map[string][2]string{
+		engine.verificationKeyID(): {"RSA", "RS512"},
+		"key-RS256":                {"RSA", "RS256"},
+		"key-ES256":                {"EC", "ES256"},
+		"key-ES384":                {"EC", "ES384"},
+		"key-ES512":                {"EC", "ES512"},
+		"key-" + AlgorithmEdDSA:    {"OKP", "EdDSA"},
+	}
+	for _, key := range keySet.Keys {
+		if expected[key["kid"]] != [2]string{key["kty"], key["alg"]} {
+			t.Errorf("Wrong key: %v", key)
+		}
+
+		if key["use"] != "sig" {
+			t.Errorf("Wrong key use: %v", key)
+		}
+
+		if key["kty"] == "RSA" && key["e"] != "AQAB" {
+			t.Errorf("Wrong RSA exponent: %v", key["e"])
+		}
+
+		if key["d"] != "" {
+			t.Errorf("Private key published: %v", key)
+		}
+	}
+}
+
+func TestCreateVerifierEngine(t *testing.T) {
+	keyring := createTestJWKSKeyring(t)
+	engine := CreateEngineWithKeyring(keyring, time.Hour)
+
+	jwks, err := engine.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := CreateVerifierEngine(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range keyring.Keys() {
+		if err = keyring.SetCurrent(key.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claims, err := verifier.ParseJWT(tokenString)
+		if err != nil {
+			t.Errorf("%v: cannot decode token: %v", key.ID, err)
+		} else if claims.SharedSecret != "mygreatpassword" {
+			t.Errorf("%v: shared secret isn't preserved: %v", key.ID, claims.SharedSecret)
+		}
+	}
+
+	if _, err = verifier.CreateJWT("myself", "mygreatpassword"); !errors.Is(err, ErrNoCurrentKey) {
+		t.Errorf("Token created by a verifier: %v", err)
+	}
+}
+
+func TestCreateVerifierEngineWithoutKeyring(t *testing.T) {
+	for algorithm, keyFile := range testKeyFiles {
+		engine, err := createTestEngineWithAlgorithm(algorithm, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jwks, err := engine.JWKS()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verifier, err := CreateVerifierEngine(jwks)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = verifier.ParseJWT(tokenString); err != nil {
+			t.Errorf("%v: cannot decode token: %v", algorithm, err)
+		}
+
+		// The engine still verifies its own tokens
+		if _, err = engine.ParseJWT(tokenString); err != nil {
+			t.Errorf("%v: cannot decode token: %v", algorithm, err)
+		}
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// The example of RFC 7638, section 3.1
+	jwk := jsonWebKey{
+		KeyType: jwkTypeRSA,
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXj" +
+			"BZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqz" +
+			"s8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-" +
+			"G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E: "AQAB",
+	}
+
+	if thumbprint := jwkThumbprint(jwk); thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("Wrong thumbprint: %v", thumbprint)
+	}
+}
+
+func TestCreateVerifierEngineIgnoredKeys(t *testing.T) {
+	jwks := []byte(`{"keys":[
+		{"kty":"oct","kid":"hmac","alg":"HS256","k":"c2VjcmV0"},
+		{"kty":"OKP","crv":"Ed25519","alg":"EdDSA","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		{"kty":"OKP","crv":"Ed25519","kid":"enc","use":"enc","alg":"EdDSA",
+		 "x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		{"kty":"OKP","crv":"Ed25519","kid":"good","alg":"EdDSA","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}
+	]}`)
+
+	verifier, err := CreateVerifierEngine(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := verifier.Keyring.Keys()
+	if len(keys) != 1 || keys[0].ID != "good" {
+		t.Errorf("Wrong keys: %v", keys)
+	}
+}
+
+func TestCreateVerifierEngineInvalid(t *testing.T) {
+	invalid := []string{
+		`not json`,
+		`{}`,
+		// Wrong key type for the algorithm
+		`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"a","alg":"ES256",` +
+			`"x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`,
+		// Point not on the curve
+		`{"keys":[{"kty":"EC","crv":"P-256","kid":"a","alg":"ES256",` +
+			`"x":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA","y":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAE"}]}`,
+		// Wrong curve for the algorithm
+		`{"keys":[{"kty":"EC","crv":"P-384","kid":"a","alg":"ES256","x":"AA","y":"AA"}]}`,
+		// RSA key too short
+		`{"keys":[{"kty":"RSA","kid":"a","alg":"RS256","n":"AQAB","e":"AQAB"}]}`,
+	}
+
+	for _, jwks := range invalid {
+		if _, err := CreateVerifierEngine([]byte(jwks)); err == nil {
+			t.Errorf("Invalid JWKS accepted: %v", jwks)
+		}
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	engine := CreateEngineWithKeyring(createTestJWKSKeyring(t), time.Hour)
+	server := httptest.NewServer(engine.JWKSHandler())
+	defer server.Close()
+
+	response, err := http.Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong status code: %v", response.StatusCode)
+	}
+
+	response, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+
+	if response.Header.Get("Content-Type") != "application/jwk-set+json" {
+		t.Errorf("Wrong content type: %v", response.Header.Get("Content-Type"))
+	}
+}
+
+func TestCreateVerifierEngineFromURL(t *testing.T) {
+	keyring := NewKeyring()
+	if err := keyring.Add(createTestKey(t, "first", AlgorithmES256)); err != nil {
+		t.Fatal(err)
+	}
+	engine := CreateEngineWithKeyring(keyring, 24*time.Hour)
+
+	var requests int32
+	handler := engine.JWKSHandler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	verifier, err := CreateVerifierEngineFromURL(nil, server.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	verifier.NowFunc = func() time.Time {
+		return now
+	}
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = verifier.ParseJWT(tokenString); err != nil {
+		t.Error("Cannot decode token", err)
+	}
+
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("Key set not cached, %v requests", requests)
+	}
+
+	// A new key is downloaded when a token is signed with it, but not more
+	// than once a minute
+	if err = keyring.Add(createTestKey(t, "second", AlgorithmEdDSA)); err != nil {
+		t.Fatal(err)
+	}
+	if err = keyring.SetCurrent("second"); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err = engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(jwksMinRefreshInterval / 2)
+	if _, err = verifier.ParseJWT(tokenString); err == nil {
+		t.Error("Key set downloaded too often")
+	}
+
+	now = now.Add(jwksMinRefreshInterval)
+	if _, err = verifier.ParseJWT(tokenString); err != nil {
+		t.Error("Cannot decode token", err)
+	}
+
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("Wrong number of requests: %v", requests)
+	}
+
+	// The key set is downloaded again after the refresh interval, and the
+	// cached keys are used if the server is not responding
+	server.Close()
+	now = now.Add(2 * time.Hour)
+	if _, err = verifier.ParseJWT(tokenString); err != nil {
+		t.Error("Cached keys not used", err)
+	}
+
+	if err = verifier.RefreshJWKS(); err == nil {
+		t.Error("Download error not reported")
+	}
+}
+
+func TestCreateVerifierEngineFromURLSlowServer(t *testing.T) {
+	keyring := NewKeyring()
+	if err := keyring.Add(createTestKey(t, "first", AlgorithmES256)); err != nil {
+		t.Fatal(err)
+	}
+	engine := CreateEngineWithKeyring(keyring, 24*time.Hour)
+
+	var requests int32
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	handler := engine.JWKSHandler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			received <- struct{}{}
+			<-release
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	verifier, err := CreateVerifierEngineFromURL(nil, server.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Add(2 * time.Hour)
+	verifier.NowFunc = func() time.Time {
+		return now
+	}
+
+	tokenString, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first token starts the download of the expired key set
+	done := make(chan error)
+	go func() {
+		_, err := verifier.ParseJWT(tokenString)
+		done <- err
+	}()
+	<-received
+
+	// The other tokens are verified with the cached keys in the meantime
+	parsed := make(chan error)
+	go func() {
+		_, err := verifier.ParseJWT(tokenString)
+		parsed <- err
+	}()
+
+	select {
+	case err = <-parsed:
+		if err != nil {
+			t.Error("Cannot decode token", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Verification blocked by the download")
+	}
+
+	close(release)
+	if err = <-done; err != nil {
+		t.Error("Cannot decode token", err)
+	}
+
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("Wrong number of requests: %v", requests)
+	}
+}
+
+func TestCreateVerifierEngineFromURLNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := CreateVerifierEngineFromURL(server.Client(), server.URL, 0); err == nil {
+		t.Error("Missing key set not detected")
+	}
+}
@@ -0,0 +1,174 @@
+package jwt
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJWKSRefreshInterval is the refresh interval used when none is
+	// passed to CreateVerifierEngineFromURL
+	DefaultJWKSRefreshInterval = time.Hour
+
+	// jwksMinRefreshInterval limits the requests made when a token is
+	// signed with an unknown key, or when the server is not responding
+	jwksMinRefreshInterval = time.Minute
+
+	jwksFetchTimeout = 10 * time.Second
+	jwksMaxSize      = 1024 * 1024
+)
+
+// jwksFetcher keeps the keyring of a verifier engine synchronized with a
+// JSON Web Key Set served by an URL
+type jwksFetcher struct {
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+	keyring         *Keyring
+
+	// The mutex protects the following fields, and is not held during the
+	// download, so the cached keys can be used in the meantime
+	mutex sync.Mutex
+
+	// The time of the last successful fetch
+	fetchedAt time.Time
+
+	// The time of the last fetch, successful or not
+	attemptedAt time.Time
+
+	// The sequence number of the last fetch started and of the one whose
+	// keys are in the keyring, used to discard the keys of a fetch completed
+	// after a newer one
+	started uint64
+	applied uint64
+}
+
+// CreateVerifierEngineFromURL create a new JWT engine which only verifies
+// the tokens, using the keys of a JSON Web Key Set downloaded from an URL,
+// as the one served by Engine.JWKSHandler.
+//
+// The key set is cached and downloaded again after the refresh interval or
+// when a token is signed with an unknown key, but not more often than once a
+// minute. If the download fails the cached keys continue to be used. If the
+// client is nil, a client with a timeout of ten seconds is used.
+func CreateVerifierEngineFromURL(client *http.Client, url string, refreshInterval time.Duration) (*Engine, error) {
+	if client == nil {
+		client = &http.Client{Timeout: jwksFetchTimeout}
+	}
+
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultJWKSRefreshInterval
+	}
+
+	engine := CreateEngineWithKeyring(NewKeyring(), 0)
+	engine.jwks = &jwksFetcher{
+		url:             url,
+		client:          client,
+		refreshInterval: refreshInterval,
+		keyring:         engine.Keyring,
+	}
+
+	if err := engine.RefreshJWKS(); err != nil {
+		return nil, fmt.Errorf("CreateVerifierEngineFromURL: %w", err)
+	}
+
+	return engine, nil
+}
+
+// RefreshJWKS downloads again the JSON Web Key Set of an engine created by
+// CreateVerifierEngineFromURL. It does nothing for the other engines
+func (e *Engine) RefreshJWKS() error {
+	if e.jwks == nil {
+		return nil
+	}
+
+	now := e.NowFunc()
+
+	e.jwks.mutex.Lock()
+	sequence := e.jwks.begin(now)
+	e.jwks.mutex.Unlock()
+
+	return e.jwks.fetch(sequence, now)
+}
+
+// refreshJWKS downloads again the JSON Web Key Set if it is older than the
+// refresh interval or, when forced, if a token is signed with an unknown
+// key. Only one download at a time is started, while the other callers
+// continue to use the cached keys. Errors are ignored, since the cached keys
+// can still be used
+func (e *Engine) refreshJWKS(force bool) {
+	if e.jwks == nil {
+		return
+	}
+
+	maxAge := e.jwks.refreshInterval
+	if force {
+		maxAge = 0
+	}
+
+	now := e.NowFunc()
+
+	e.jwks.mutex.Lock()
+	if now.Sub(e.jwks.fetchedAt) < maxAge || now.Sub(e.jwks.attemptedAt) < jwksMinRefreshInterval {
+		e.jwks.mutex.Unlock()
+		return
+	}
+	sequence := e.jwks.begin(now)
+	e.jwks.mutex.Unlock()
+
+	_ = e.jwks.fetch(sequence, now)
+}
+
+// begin records the start of a fetch, returning its sequence number. The
+// caller must hold the mutex
+func (fetcher *jwksFetcher) begin(now time.Time) uint64 {
+	fetcher.attemptedAt = now
+	fetcher.started++
+	return fetcher.started
+}
+
+// fetch downloads the JSON Web Key Set and replaces the keys of the keyring,
+// unless the keys of a newer fetch are already there. The caller must not
+// hold the mutex
+func (fetcher *jwksFetcher) fetch(sequence uint64, now time.Time) error {
+	keys, err := fetcher.download()
+	if err != nil {
+		return err
+	}
+
+	fetcher.mutex.Lock()
+	defer fetcher.mutex.Unlock()
+
+	if sequence < fetcher.applied {
+		return nil
+	}
+
+	fetcher.keyring.replace(keys)
+	fetcher.applied = sequence
+	fetcher.fetchedAt = now
+	return nil
+}
+
+// download downloads and parses the JSON Web Key Set
+func (fetcher *jwksFetcher) download() ([]*Key, error) {
+	response, err := fetcher.client.Get(fetcher.url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot download JWKS: %v", response.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, jwksMaxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseJWKS(data)
+}
@@ -12,49 +12,151 @@ recover the master key from the credential record.
 package jwt
 
 import (
+	"crypto"
 	"crypto/rsa"
+	"errors"
 	"fmt"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
+var (
+	// ErrConflictingKeys is returned when both the RSA key fields of an
+	// Engine and the generic ones are set, with different keys
+	ErrConflictingKeys = errors.New("engine has conflicting keys")
+)
+
 // Engine represent a JWT signing configuration
 type Engine struct {
 	// The function to use to extract the current timestamp, stored here since
 	// it's useful to inject a mock one during the unit tests
 	NowFunc func() time.Time
 
-	// The private key, used to sign a token
+	// The signing algorithm, such as AlgorithmRS512 or AlgorithmEdDSA. If
+	// empty AlgorithmRS512 is used. Tokens signed with other algorithms are
+	// rejected
+	Algorithm string
+
+	// The private key, used to sign a token with an RSA algorithm
 	PrivateKey *rsa.PrivateKey
 
-	// The public key, used to verify a token
+	// The public key, used to verify a token signed with an RSA algorithm
 	PublicKey *rsa.PublicKey
 
+	// The private key, used to sign a token with the other algorithms. Its
+	// type depends on the algorithm: *ecdsa.PrivateKey or ed25519.PrivateKey.
+	// An *rsa.PrivateKey is accepted too but, if PrivateKey is set, it must
+	// be the same key, otherwise ErrConflictingKeys is returned
+	SigningKey crypto.PrivateKey
+
+	// The public key, used to verify a token signed with the other
+	// algorithms. Its type depends on the algorithm: *ecdsa.PublicKey or
+	// ed25519.PublicKey. An *rsa.PublicKey is accepted too but, if PublicKey
+	// is set, it must be the same key, otherwise ErrConflictingKeys is
+	// returned
+	VerificationKey crypto.PublicKey
+
 	// The token duration
 	TokenDuration time.Duration
+
+	// The issuer of the tokens. If not empty, it is stored in the `iss`
+	// claim and the tokens with another issuer are rejected
+	Issuer string
+
+	// The audience of the tokens, i.e. the service accepting them. If not
+	// empty, it is stored in the `aud` claim and the tokens with another
+	// audience are rejected
+	Audience string
+
+	// The clock skew tolerated when validating the `exp`, `nbf` and `iat`
+	// claims
+	Leeway time.Duration
+
+	// The store of the revoked tokens, consulted by ParseJWT. If this is nil
+	// the tokens can't be revoked
+	Revocations RevocationStore
+
+	// The store of the refresh tokens. If this is nil the sessions can't be
+	// refreshed, see CreateRefreshableJWT
+	RefreshTokens RefreshStore
+
+	// The refresh token duration. Every refresh extends the session by this
+	// duration
+	RefreshDuration time.Duration
+
+	// The private key, used to decrypt an encrypted token
+	EncryptionPrivateKey *rsa.PrivateKey
+
+	// The public key, used to encrypt a token. If this is nil the tokens
+	// are only signed, see SetEncryptionKeys
+	EncryptionPublicKey *rsa.PublicKey
+
+	// The keys used to sign and verify the tokens. If this is not nil the
+	// tokens are signed with the current key of the keyring, and the key
+	// used to verify them is chosen by their `kid` header. The tokens
+	// without a `kid` header are still verified with PublicKey
+	Keyring *Keyring
+
+	// The source of the keyring for the engines created by
+	// CreateVerifierEngineFromURL
+	jwks *jwksFetcher
 }
 
-// CreateEngine create a new JWT signing engine with a key pair encoded in PEM
-// format. Beware that the private key must have no passphrase protection, and
-// need to be stored securely.
+// CreateEngine create a new JWT signing engine with an RSA key pair encoded
+// in PEM format, signing the tokens with RS512. Beware that the private key
+// must have no passphrase protection, and need to be stored securely.
 func CreateEngine(privateKeyBytes []byte, publicKeyBytes []byte, tokenDuration time.Duration) (*Engine, error) {
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyBytes)
-	if err != nil {
-		return nil, fmt.Errorf("CreateEngine, error decoding private key: %v", err)
+	return CreateEngineWithAlgorithm(AlgorithmRS512, privateKeyBytes, publicKeyBytes, tokenDuration)
+}
+
+// CreateEngineWithAlgorithm create a new JWT signing engine using the passed
+// signing algorithm, with a key pair encoded in PEM format. RSA keys are
+// expected in PKCS#1 or PKCS#8 format, ECDSA keys in SEC 1 or PKCS#8 format
+// and Ed25519 keys in PKCS#8 format. Public keys are expected in PKIX format.
+// The RSA keys are stored in PrivateKey and PublicKey, and the other ones in
+// SigningKey and VerificationKey. Beware that the private key
+// must have no passphrase protection, and need to be stored securely.
+func CreateEngineWithAlgorithm(
+	algorithm string, privateKeyBytes []byte, publicKeyBytes []byte, tokenDuration time.Duration) (*Engine, error) {
+	if signingMethod(algorithm) == nil {
+		return nil, fmt.Errorf("CreateEngine: %w", ErrUnsupportedAlgorithm)
 	}
 
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyBytes)
+	privateKey, publicKey, err := parseKeyPair(algorithm, privateKeyBytes, publicKeyBytes)
 	if err != nil {
-		return nil, fmt.Errorf("CreateEngine, error decoding public key: %v", err)
+		return nil, fmt.Errorf("CreateEngine, %v", err)
+	}
+
+	engine := &Engine{
+		NowFunc:       time.Now,
+		Algorithm:     algorithm,
+		TokenDuration: tokenDuration,
+	}
+
+	// The RSA keys are only stored in the RSA fields, so the callers replacing
+	// them after the creation of the engine are not ignored
+	rsaPrivateKey, isRSAPrivateKey := privateKey.(*rsa.PrivateKey)
+	rsaPublicKey, isRSAPublicKey := publicKey.(*rsa.PublicKey)
+	if isRSAPrivateKey && isRSAPublicKey {
+		engine.PrivateKey = rsaPrivateKey
+		engine.PublicKey = rsaPublicKey
+	} else {
+		engine.SigningKey = privateKey
+		engine.VerificationKey = publicKey
 	}
 
+	return engine, nil
+}
+
+// CreateEngineWithKeyring create a new JWT signing engine which signs and
+// verifies the tokens with the keys of the keyring
+func CreateEngineWithKeyring(keyring *Keyring, tokenDuration time.Duration) *Engine {
 	return &Engine{
 		NowFunc:       time.Now,
-		PrivateKey:    privateKey,
-		PublicKey:     publicKey,
 		TokenDuration: tokenDuration,
-	}, nil
+		Keyring:       keyring,
+	}
 }
 
 // CustomClaims is the structure with the claims inside this JWT token.
@@ -63,50 +165,222 @@ type CustomClaims struct {
 	jwt.StandardClaims
 }
 
-// CreateCustomClaims create our custom set of claims
+// CreateCustomClaims create our custom set of claims. The token ID is not
+// set, since it is generated by CreateJWT
 func (e *Engine) CreateCustomClaims(subject string, sharedSecret string) *CustomClaims {
-	expiration := e.NowFunc().Add(e.TokenDuration).Unix()
+	now := e.NowFunc()
 	return &CustomClaims{
 		SharedSecret: sharedSecret,
 		StandardClaims: jwt.StandardClaims{
 			Subject:   subject,
-			ExpiresAt: expiration,
+			Issuer:    e.Issuer,
+			Audience:  e.Audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(e.TokenDuration).Unix(),
 		},
 	}
 }
 
-// CreateJWT create a new JWT and sign it with the given parameters
+// CreateJWT create a new JWT and sign it with the given parameters. Every
+// token has a random ID, stored in the `jti` claim. If the encryption is
+// enabled the signed token is then encrypted
 func (e *Engine) CreateJWT(subject string, sharedSecret string) (string, error) {
 	claims := e.CreateCustomClaims(subject, sharedSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
-	return token.SignedString(e.PrivateKey)
+
+	var err error
+	if claims.Id, err = newTokenID(); err != nil {
+		return "", fmt.Errorf("CreateJWT: %v", err)
+	}
+
+	signedToken, err := e.sign(claims)
+	if err != nil || e.EncryptionPublicKey == nil {
+		return signedToken, err
+	}
+
+	return encryptJWT(signedToken, e.EncryptionPublicKey)
 }
 
-// ParseJWT parse and validate a token and gets the claims
+// ParseJWT parse and validate a token and gets the claims. Encrypted tokens
+// are decrypted before being validated
 func (e *Engine) ParseJWT(tokenString string) (*CustomClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, e.keyFunc)
+	if IsEncryptedJWT(tokenString) {
+		if e.EncryptionPrivateKey == nil {
+			return nil, ErrNoDecryptionKey
+		}
+
+		signedToken, err := decryptJWT(tokenString, e.EncryptionPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		tokenString = signedToken
+	}
+
+	// The claims are validated by validateClaims, which uses NowFunc
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(tokenString, &CustomClaims{}, e.keyFunc)
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("wrong claims")
+	}
+
+	if err = e.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	if err = e.checkRevocation(claims); err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("wrong claims")
+	return claims, nil
 }
 
+// sign signs the claims with the current key of the keyring or, if there
+// is no keyring, with the private key of the engine
+func (e *Engine) sign(claims jwt.Claims) (string, error) {
+	if e.Keyring == nil {
+		method := e.signingMethod()
+		if method == nil {
+			return "", fmt.Errorf("CreateJWT: %w", ErrUnsupportedAlgorithm)
+		}
+
+		privateKey, err := e.signingKey()
+		if err != nil {
+			return "", fmt.Errorf("CreateJWT: %w", err)
+		}
+
+		token := jwt.NewWithClaims(method, claims)
+		if id := e.verificationKeyID(); id != "" {
+			token.Header["kid"] = id
+		}
+		return token.SignedString(privateKey)
+	}
+
+	key := e.Keyring.Current()
+	if key == nil {
+		return "", fmt.Errorf("CreateJWT: %w", ErrNoCurrentKey)
+	}
+
+	if key.PrivateKey == nil {
+		return "", fmt.Errorf("CreateJWT, key %v: %w", key.ID, ErrNoSigningKey)
+	}
+
+	token := jwt.NewWithClaims(signingMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// keyFunc gets the key to verify a token, checking that the token is signed
+// with the algorithm of the key
 func (e *Engine) keyFunc(token *jwt.Token) (interface{}, error) {
-	var method *jwt.SigningMethodRSA
-	var ok bool
+	if kid, ok := token.Header["kid"]; ok && e.Keyring != nil {
+		id, ok := kid.(string)
+		if !ok {
+			return nil, fmt.Errorf("token has wrong key ID")
+		}
+
+		// The tokens signed with the key of the engine carry its ID
+		if id != e.verificationKeyID() {
+			return e.keyringKeyFunc(token, id)
+		}
+	}
 
-	if method, ok = token.Method.(*jwt.SigningMethodRSA); !ok {
+	publicKey, err := e.verificationKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if publicKey == nil || !hasSigningMethod(token, e.signingMethod()) {
 		return nil, fmt.Errorf("token has wrong signing method")
 	}
 
-	if method != jwt.SigningMethodRS512 {
-		return nil, fmt.Errorf("signing method has wrong density")
+	return publicKey, nil
+}
+
+// keyringKeyFunc gets the key of the keyring to verify a token, checking that
+// the token is signed with the algorithm of the key
+func (e *Engine) keyringKeyFunc(token *jwt.Token, id string) (interface{}, error) {
+	e.refreshJWKS(false)
+	key := e.Keyring.Get(id)
+	if key == nil {
+		// The key could have been added after the last refresh
+		e.refreshJWKS(true)
+		key = e.Keyring.Get(id)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("key %v: %w", id, ErrUnknownKey)
+	}
+
+	if !hasSigningMethod(token, signingMethod(key.Algorithm)) {
+		return nil, fmt.Errorf("token has wrong signing method")
+	}
+
+	return key.PublicKey, nil
+}
+
+// signingKey gets the key used to sign the tokens without a keyring:
+// PrivateKey or SigningKey. ErrConflictingKeys is returned if both are set
+// with different keys
+func (e *Engine) signingKey() (crypto.PrivateKey, error) {
+	switch {
+	case e.PrivateKey == nil && e.SigningKey == nil:
+		return nil, nil
+	case e.SigningKey == nil:
+		return e.PrivateKey, nil
+	case e.PrivateKey == nil:
+		return e.SigningKey, nil
+	}
+
+	signingKey, ok := e.SigningKey.(*rsa.PrivateKey)
+	if !ok || !isSameRSAPublicKey(&e.PrivateKey.PublicKey, &signingKey.PublicKey) ||
+		e.PrivateKey.D.Cmp(signingKey.D) != 0 {
+		return nil, ErrConflictingKeys
+	}
+
+	return e.PrivateKey, nil
+}
+
+// verificationKey gets the key used to verify the tokens signed without a
+// keyring: PublicKey or VerificationKey. ErrConflictingKeys is returned if
+// both are set with different keys
+func (e *Engine) verificationKey() (crypto.PublicKey, error) {
+	switch {
+	case e.PublicKey == nil && e.VerificationKey == nil:
+		return nil, nil
+	case e.VerificationKey == nil:
+		return e.PublicKey, nil
+	case e.PublicKey == nil:
+		return e.VerificationKey, nil
+	}
+
+	verificationKey, ok := e.VerificationKey.(*rsa.PublicKey)
+	if !ok || !isSameRSAPublicKey(e.PublicKey, verificationKey) {
+		return nil, ErrConflictingKeys
 	}
 
 	return e.PublicKey, nil
 }
+
+// isSameRSAPublicKey check if two RSA public keys are the same
+func isSameRSAPublicKey(first *rsa.PublicKey, second *rsa.PublicKey) bool {
+	return first.E == second.E && first.N.Cmp(second.N) == 0
+}
+
+// signingMethod gets the signing method of this engine
+func (e *Engine) signingMethod() jwt.SigningMethod {
+	if e.Algorithm == "" {
+		return jwt.SigningMethodRS512
+	}
+
+	return signingMethod(e.Algorithm)
+}
+
+// hasSigningMethod check if a token is signed with the passed method
+func hasSigningMethod(token *jwt.Token, method jwt.SigningMethod) bool {
+	return method != nil && token.Method != nil &&
+		token.Method.Alg() == method.Alg() && token.Method == method
+}
@@ -0,0 +1,259 @@
+package jwt
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrUnknownKey is returned when a key is not in the keyring
+	ErrUnknownKey = errors.New("unknown key")
+
+	// ErrDuplicateKey is returned when adding a key whose ID is already in
+	// the keyring
+	ErrDuplicateKey = errors.New("duplicate key ID")
+
+	// ErrCurrentKey is returned when retiring or removing the current key
+	ErrCurrentKey = errors.New("the current key can't be retired or removed")
+
+	// ErrKeyRetired is returned when using a retired key to sign tokens
+	ErrKeyRetired = errors.New("key is retired")
+
+	// ErrNoCurrentKey is returned when creating a token with a keyring
+	// without a current key
+	ErrNoCurrentKey = errors.New("no current signing key")
+
+	// ErrNoSigningKey is returned when the current key has no private key
+	ErrNoSigningKey = errors.New("key can't be used for signing")
+)
+
+// Key is a key used to sign and verify tokens, identified in the tokens by
+// the `kid` header
+type Key struct {
+	// The ID of the key, stored in the `kid` header of the tokens
+	ID string
+
+	// The signing algorithm, such as AlgorithmRS512 or AlgorithmEdDSA
+	Algorithm string
+
+	// The private key, used to sign a token. It can be nil for the keys
+	// used only to verify the tokens
+	PrivateKey crypto.PrivateKey
+
+	// The public key, used to verify a token
+	PublicKey crypto.PublicKey
+}
+
+// NewKey creates a new key with a key pair encoded in PEM format, as in
+// CreateEngineWithAlgorithm. The private key can be nil for the keys used
+// only to verify the tokens.
+func NewKey(id string, algorithm string, privateKeyBytes []byte, publicKeyBytes []byte) (*Key, error) {
+	if signingMethod(algorithm) == nil {
+		return nil, fmt.Errorf("NewKey: %w", ErrUnsupportedAlgorithm)
+	}
+
+	var privateKey crypto.PrivateKey
+	if privateKeyBytes != nil {
+		var err error
+		privateKey, err = parsePrivateKey(algorithm, privateKeyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("NewKey, error decoding private key: %v", err)
+		}
+	}
+
+	publicKey, err := parsePublicKey(algorithm, publicKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("NewKey, error decoding public key: %v", err)
+	}
+
+	return &Key{
+		ID:         id,
+		Algorithm:  algorithm,
+		PrivateKey: privateKey,
+		PublicKey:  publicKey,
+	}, nil
+}
+
+// keyringEntry is a key inside the keyring
+type keyringEntry struct {
+	key     *Key
+	retired bool
+}
+
+/*
+Keyring is a set of keys used to sign and verify the tokens, which allows
+the signing key to be rotated without invalidating the tokens already issued.
+
+Tokens are signed with the current key, and are verified with the key
+identified by their `kid` header. A key rotation is made by:
+
+1. adding the new key with Add;
+2. promoting the new key with SetCurrent, so the old key is only used to
+verify the tokens;
+3. retiring the old key with Retire, which is optional but makes sure it will
+not be promoted again by mistake;
+4. removing the old key with Remove, after the tokens signed with it expire.
+
+When the engine runs on more than one server, the new key should be added
+everywhere before being promoted. A Keyring is safe for concurrent use.
+*/
+type Keyring struct {
+	mutex   sync.RWMutex
+	keys    map[string]*keyringEntry
+	current string
+}
+
+// NewKeyring creates an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[string]*keyringEntry),
+	}
+}
+
+// Add adds a key to the keyring. The first key added becomes the current
+// one
+func (keyring *Keyring) Add(key *Key) error {
+	if signingMethod(key.Algorithm) == nil {
+		return fmt.Errorf("Add: %w", ErrUnsupportedAlgorithm)
+	}
+
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	if _, ok := keyring.keys[key.ID]; ok {
+		return fmt.Errorf("Add, key %v: %w", key.ID, ErrDuplicateKey)
+	}
+
+	keyring.keys[key.ID] = &keyringEntry{key: key}
+	if keyring.current == "" && key.PrivateKey != nil {
+		keyring.current = key.ID
+	}
+
+	return nil
+}
+
+// SetCurrent sets the key used to sign the new tokens
+func (keyring *Keyring) SetCurrent(id string) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	entry, ok := keyring.keys[id]
+	if !ok {
+		return fmt.Errorf("SetCurrent, key %v: %w", id, ErrUnknownKey)
+	}
+
+	if entry.retired {
+		return fmt.Errorf("SetCurrent, key %v: %w", id, ErrKeyRetired)
+	}
+
+	if entry.key.PrivateKey == nil {
+		return fmt.Errorf("SetCurrent, key %v: %w", id, ErrNoSigningKey)
+	}
+
+	keyring.current = id
+	return nil
+}
+
+// Retire marks a key as retiring: it will be used to verify the tokens
+// signed with it, but it can't become the current key anymore
+func (keyring *Keyring) Retire(id string) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	entry, ok := keyring.keys[id]
+	if !ok {
+		return fmt.Errorf("Retire, key %v: %w", id, ErrUnknownKey)
+	}
+
+	if keyring.current == id {
+		return fmt.Errorf("Retire, key %v: %w", id, ErrCurrentKey)
+	}
+
+	entry.retired = true
+	return nil
+}
+
+// Remove removes a key from the keyring. The tokens signed with it will be
+// rejected
+func (keyring *Keyring) Remove(id string) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	if _, ok := keyring.keys[id]; !ok {
+		return fmt.Errorf("Remove, key %v: %w", id, ErrUnknownKey)
+	}
+
+	if keyring.current == id {
+		return fmt.Errorf("Remove, key %v: %w", id, ErrCurrentKey)
+	}
+
+	delete(keyring.keys, id)
+	return nil
+}
+
+// Current gets the key used to sign the new tokens, nil if there is none
+func (keyring *Keyring) Current() *Key {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	if entry, ok := keyring.keys[keyring.current]; ok {
+		return entry.key
+	}
+
+	return nil
+}
+
+// Get gets a key by ID, nil if the key is not in the keyring
+func (keyring *Keyring) Get(id string) *Key {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	if entry, ok := keyring.keys[id]; ok {
+		return entry.key
+	}
+
+	return nil
+}
+
+// IsRetired check if a key has been retired
+func (keyring *Keyring) IsRetired(id string) bool {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	entry, ok := keyring.keys[id]
+	return ok && entry.retired
+}
+
+// Keys gets all the keys in the keyring, sorted by ID
+func (keyring *Keyring) Keys() []*Key {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	result := make([]*Key, 0, len(keyring.keys))
+	for _, entry := range keyring.keys {
+		result = append(result, entry.key)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// replace replaces all the keys of the keyring, leaving it without a current
+// key. It is used for the keyrings loaded from a JSON Web Key Set
+func (keyring *Keyring) replace(keys []*Key) {
+	entries := make(map[string]*keyringEntry, len(keys))
+	for _, key := range keys {
+		entries[key.ID] = &keyringEntry{key: key}
+	}
+
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	keyring.keys = entries
+	keyring.current = ""
+}
@@ -0,0 +1,246 @@
+package jwt
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func createTestKey(t *testing.T, id string, algorithm string) *Key {
+	privateKey, err := ioutil.ReadFile("testdata/" + testKeyFiles[algorithm] + ".key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := ioutil.ReadFile("testdata/" + testKeyFiles[algorithm] + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := NewKey(id, algorithm, privateKey, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func createTestKeyring(t *testing.T) *Keyring {
+	keyring := NewKeyring()
+	if err := keyring.Add(createTestKey(t, "first", AlgorithmES256)); err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func tokenKeyID(t *testing.T, tokenString string) interface{} {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, &CustomClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.Header["kid"]
+}
+
+func TestKeyringRotation(t *testing.T) {
+	keyring := createTestKeyring(t)
+	engine := CreateEngineWithKeyring(keyring, time.Hour)
+
+	oldToken, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kid := tokenKeyID(t, oldToken); kid != "first" {
+		t.Errorf("Wrong key ID: %v", kid)
+	}
+
+	if err = keyring.Add(createTestKey(t, "second", AlgorithmEdDSA)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = keyring.SetCurrent("second"); err != nil {
+		t.Fatal(err)
+	}
+
+	newToken, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kid := tokenKeyID(t, newToken); kid != "second" {
+		t.Errorf("Wrong key ID: %v", kid)
+	}
+
+	if err = keyring.Retire("first"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tokenString := range []string{oldToken, newToken} {
+		claims, err := engine.ParseJWT(tokenString)
+		if err != nil {
+			t.Error("Cannot decode token", err)
+		} else if claims.SharedSecret != "mygreatpassword" {
+			t.Errorf("Shared secret isn't preserved: %v", claims.SharedSecret)
+		}
+	}
+
+	if err = keyring.SetCurrent("first"); !errors.Is(err, ErrKeyRetired) {
+		t.Errorf("Retired key promoted: %v", err)
+	}
+
+	if err = keyring.Remove("first"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = engine.ParseJWT(oldToken); err == nil {
+		t.Error("Token signed with a removed key accepted")
+	}
+
+	if _, err = engine.ParseJWT(newToken); err != nil {
+		t.Error("Cannot decode token", err)
+	}
+}
+
+func TestKeyringCurrentKey(t *testing.T) {
+	keyring := createTestKeyring(t)
+
+	if err := keyring.Retire("first"); !errors.Is(err, ErrCurrentKey) {
+		t.Errorf("Current key retired: %v", err)
+	}
+
+	if err := keyring.Remove("first"); !errors.Is(err, ErrCurrentKey) {
+		t.Errorf("Current key removed: %v", err)
+	}
+
+	if err := keyring.SetCurrent("nobody"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Unknown key promoted: %v", err)
+	}
+
+	if err := keyring.Add(createTestKey(t, "first", AlgorithmRS512)); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("Duplicate key added: %v", err)
+	}
+}
+
+func TestKeyringVerificationOnlyKey(t *testing.T) {
+	publicKey, err := ioutil.ReadFile("testdata/ed25519.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := NewKey("public", AlgorithmEdDSA, nil, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := NewKeyring()
+	if err = keyring.Add(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if keyring.Current() != nil {
+		t.Error("Verification only key used for signing")
+	}
+
+	if err = keyring.SetCurrent("public"); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("Verification only key promoted: %v", err)
+	}
+
+	engine := CreateEngineWithKeyring(keyring, time.Hour)
+	if _, err = engine.CreateJWT("myself", "mygreatpassword"); !errors.Is(err, ErrNoCurrentKey) {
+		t.Errorf("Token created without a current key: %v", err)
+	}
+
+	// Tokens signed with the matching private key are accepted
+	signingKeyring := NewKeyring()
+	if err = signingKeyring.Add(createTestKey(t, "public", AlgorithmEdDSA)); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := CreateEngineWithKeyring(signingKeyring, time.Hour).CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = engine.ParseJWT(tokenString); err != nil {
+		t.Error("Cannot decode token", err)
+	}
+}
+
+func TestKeyringUnknownKey(t *testing.T) {
+	engine := CreateEngineWithKeyring(createTestKeyring(t), time.Hour)
+
+	otherKeyring := NewKeyring()
+	if err := otherKeyring.Add(createTestKey(t, "other", AlgorithmES256)); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := CreateEngineWithKeyring(otherKeyring, time.Hour).CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = engine.ParseJWT(tokenString); err == nil {
+		t.Error("Token with unknown key accepted")
+	}
+}
+
+func TestKeyringAlgorithmPinning(t *testing.T) {
+	keyring := createTestKeyring(t)
+	engine := CreateEngineWithKeyring(keyring, time.Hour)
+
+	// A token claiming to be signed with the "first" key, but using
+	// another algorithm
+	key := createTestKey(t, "first", AlgorithmES384)
+	token := jwt.NewWithClaims(jwt.SigningMethodES384, engine.CreateCustomClaims("myself", "mygreatpassword"))
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = engine.ParseJWT(tokenString); err == nil {
+		t.Error("Token with wrong algorithm accepted")
+	}
+}
+
+func TestKeyringTokenWithoutKeyID(t *testing.T) {
+	engine, err := createTestEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldToken, err := engine.CreateJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens created before adopting the keyring are verified with the
+	// public key of the engine
+	engine.Keyring = createTestKeyring(t)
+	if _, err = engine.ParseJWT(oldToken); err != nil {
+		t.Error("Cannot decode token", err)
+	}
+
+	keyringEngine := CreateEngineWithKeyring(engine.Keyring, time.Hour)
+	if _, err = keyringEngine.ParseJWT(oldToken); err == nil {
+		t.Error("Token without key ID accepted")
+	}
+}
+
+func TestKeyringKeys(t *testing.T) {
+	keyring := createTestKeyring(t)
+	if err := keyring.Add(createTestKey(t, "another", AlgorithmRS256)); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := keyring.Keys()
+	if len(keys) != 2 || keys[0].ID != "another" || keys[1].ID != "first" {
+		t.Errorf("Wrong keys: %v", keys)
+	}
+
+	if keyring.Current().ID != "first" {
+		t.Errorf("Wrong current key: %v", keyring.Current().ID)
+	}
+}
@@ -0,0 +1,482 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/cryptico"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/hash"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/keygen"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/utils"
+	"golang.org/x/crypto/hkdf"
+)
+
+/*
+Refresh tokens are opaque strings composed by the token ID and a random
+secret, both encoded in base64url and separated by a dot. The server stores,
+for every refresh token, a RefreshRecord containing:
+
+- the hash of the secret, created with internal/hash;
+- the shared secret of the session, encrypted with a key derived from the
+secret of the refresh token via HKDF-SHA256.
+
+This way, the stored records can't be used to create access tokens or to
+recover the shared secret of the sessions.
+
+Every refresh token can be used only once: refreshing a session creates a new
+refresh token of the same family, i.e. of the same session. When an already
+used refresh token is presented, the token has been stolen, and the whole
+family is revoked.
+*/
+
+const (
+	refreshTokenIDLen     = 16
+	refreshTokenSecretLen = 32
+	refreshTokenKeyLen    = 32
+)
+
+var (
+	// ErrInvalidRefreshToken is returned when a refresh token is malformed,
+	// unknown or its secret is wrong
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+	// ErrRefreshTokenExpired is returned when a refresh token is expired
+	ErrRefreshTokenExpired = errors.New("refresh token is expired")
+
+	// ErrRefreshTokenRevoked is returned when the family of a refresh token
+	// has been revoked
+	ErrRefreshTokenRevoked = errors.New("refresh token is revoked")
+
+	// ErrRefreshTokenReused is returned when a refresh token is used twice.
+	// The family of the token is revoked
+	ErrRefreshTokenReused = errors.New("refresh token reused, session revoked")
+
+	// ErrRefreshTokenNotFound is returned by a RefreshStore when a refresh
+	// token doesn't exist
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+	// refreshTokenHashParams are the parameters used to hash the secret of
+	// the refresh tokens. Since the secret is random and has 256 bits, key
+	// stretching is useless
+	refreshTokenHashParams = keygen.Params{
+		Algorithm: keygen.AlgorithmHKDFSHA256,
+	}
+
+	refreshTokenKeyInfo = []byte("idcrypt refresh token")
+)
+
+// RefreshRecord is the server-side information about a refresh token
+type RefreshRecord struct {
+	// The ID of the refresh token
+	ID string
+
+	// The ID of the family, shared by all the refresh tokens of a session
+	FamilyID string
+
+	// When the family, i.e. the session, has been created. The session is
+	// revoked by Engine.RevokeSubject if it has been created before the
+	// revocation
+	FamilyCreatedAt time.Time
+
+	// The subject of the session
+	Subject string
+
+	// The hash of the secret of the refresh token
+	SecretHash []byte
+
+	// The shared secret of the session, encrypted with a key derived from
+	// the secret of the refresh token
+	EncryptedSharedSecret []byte
+
+	// When the refresh token expires
+	ExpiresAt time.Time
+}
+
+// RefreshStore stores the refresh tokens. Implementations must be safe for
+// concurrent use
+type RefreshStore interface {
+	// Save stores a new refresh token
+	Save(record *RefreshRecord) error
+
+	// Get gets a refresh token, returning ErrRefreshTokenNotFound if it
+	// doesn't exist. An expired refresh token must be kept until all the
+	// refresh tokens of its family expire, otherwise its reuse can't be
+	// detected
+	Get(id string) (*RefreshRecord, error)
+
+	// Use marks a refresh token as used, returning false if it was already
+	// used. This must be atomic, since two concurrent refreshes with the
+	// same token must be detected as a reuse
+	Use(id string) (bool, error)
+
+	// RevokeFamily revokes all the refresh tokens of a family
+	RevokeFamily(familyID string) error
+
+	// IsFamilyRevoked check if a family has been revoked
+	IsFamilyRevoked(familyID string) (bool, error)
+}
+
+// CreateRefreshableJWT creates a new session for the subject, returning an
+// access token, as created by CreateJWT, and a refresh token. The refresh
+// token can be used with RefreshJWT to get a new access token, with the same
+// shared secret, after the first one expires.
+func (e *Engine) CreateRefreshableJWT(subject string, sharedSecret string) (string, string, error) {
+	if e.RefreshTokens == nil {
+		return "", "", fmt.Errorf("CreateRefreshableJWT: no refresh store")
+	}
+
+	if e.RefreshDuration <= 0 {
+		return "", "", fmt.Errorf("CreateRefreshableJWT: no refresh duration")
+	}
+
+	familyID, err := generateRefreshID()
+	if err != nil {
+		return "", "", fmt.Errorf("CreateRefreshableJWT: %v", err)
+	}
+
+	refreshToken, err := e.saveRefreshToken(familyID, e.NowFunc(), subject, sharedSecret)
+	if err != nil {
+		return "", "", fmt.Errorf("CreateRefreshableJWT: %w", err)
+	}
+
+	accessToken, err := e.CreateJWT(subject, sharedSecret)
+	if err != nil {
+		return "", "", fmt.Errorf("CreateRefreshableJWT: %w", err)
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// RefreshJWT uses a refresh token to get a new access token, with the same
+// subject and shared secret. The refresh token can't be used anymore, and a
+// new one is returned. If the refresh token has already been used, the whole
+// session is revoked and ErrRefreshTokenReused is returned, even if the
+// refresh token has expired in the meantime. The session is revoked too, and
+// ErrRefreshTokenRevoked is returned, if it has been created before a
+// revocation of its subject, see RevokeSubject.
+func (e *Engine) RefreshJWT(refreshToken string) (string, string, error) {
+	if e.RefreshTokens == nil {
+		return "", "", fmt.Errorf("RefreshJWT: no refresh store")
+	}
+
+	record, secret, err := e.checkRefreshToken(refreshToken)
+	if err != nil {
+		return "", "", fmt.Errorf("RefreshJWT: %w", err)
+	}
+
+	// The reuse is checked before the expiry, since a stolen refresh token
+	// replayed after its expiry must revoke the session too
+	firstUse, err := e.RefreshTokens.Use(record.ID)
+	if err != nil {
+		return "", "", fmt.Errorf("RefreshJWT: %w", err)
+	}
+
+	if !firstUse {
+		if err = e.RefreshTokens.RevokeFamily(record.FamilyID); err != nil {
+			return "", "", fmt.Errorf("RefreshJWT: %w", err)
+		}
+		return "", "", fmt.Errorf("RefreshJWT: %w", ErrRefreshTokenReused)
+	}
+
+	if err = e.checkFamilyRevocation(record); err != nil {
+		return "", "", fmt.Errorf("RefreshJWT: %w", err)
+	}
+
+	if !e.NowFunc().Before(record.ExpiresAt) {
+		return "", "", fmt.Errorf("RefreshJWT: %w", ErrRefreshTokenExpired)
+	}
+
+	sharedSecret, err := cryptico.DecryptWithAD(
+		record.EncryptedSharedSecret, []byte(record.ID), deriveRefreshTokenKey(secret))
+	if err != nil {
+		return "", "", fmt.Errorf("RefreshJWT: %w", ErrInvalidRefreshToken)
+	}
+
+	newRefreshToken, err := e.saveRefreshToken(
+		record.FamilyID, record.FamilyCreatedAt, record.Subject, string(sharedSecret))
+	if err != nil {
+		return "", "", fmt.Errorf("RefreshJWT: %w", err)
+	}
+
+	accessToken, err := e.CreateJWT(record.Subject, string(sharedSecret))
+	if err != nil {
+		return "", "", fmt.Errorf("RefreshJWT: %w", err)
+	}
+
+	return accessToken, newRefreshToken, nil
+}
+
+// RevokeRefreshToken revokes the session of a refresh token, i.e. all the
+// refresh tokens of its family, for example on logout. The access tokens
+// already issued are still valid until they expire, unless they are revoked
+// too
+func (e *Engine) RevokeRefreshToken(refreshToken string) error {
+	if e.RefreshTokens == nil {
+		return fmt.Errorf("RevokeRefreshToken: no refresh store")
+	}
+
+	record, _, err := e.checkRefreshToken(refreshToken)
+	if errors.Is(err, ErrRefreshTokenRevoked) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("RevokeRefreshToken: %w", err)
+	}
+
+	if err = e.RefreshTokens.RevokeFamily(record.FamilyID); err != nil {
+		return fmt.Errorf("RevokeRefreshToken: %w", err)
+	}
+
+	return nil
+}
+
+// checkRefreshToken parses a refresh token, checking its secret and its
+// family, and gets its record and its secret
+func (e *Engine) checkRefreshToken(refreshToken string) (*RefreshRecord, []byte, error) {
+	parts := strings.Split(refreshToken, ".")
+	if len(parts) != 2 {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(secret) != refreshTokenSecretLen {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	record, err := e.RefreshTokens.Get(parts[0])
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	valid, err := hash.Check(secret, record.SecretHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !valid {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	revoked, err := e.RefreshTokens.IsFamilyRevoked(record.FamilyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if revoked {
+		return nil, nil, ErrRefreshTokenRevoked
+	}
+
+	return record, secret, nil
+}
+
+// checkFamilyRevocation check if the subject of a refresh token has been
+// revoked after the creation of its family. In that case the whole family is
+// revoked, and ErrRefreshTokenRevoked is returned
+func (e *Engine) checkFamilyRevocation(record *RefreshRecord) error {
+	if e.Revocations == nil {
+		return nil
+	}
+
+	revoked, err := e.Revocations.IsSubjectRevoked(record.Subject, record.FamilyCreatedAt)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRevocationCheckFailed, err)
+	}
+
+	if !revoked {
+		return nil
+	}
+
+	if err = e.RefreshTokens.RevokeFamily(record.FamilyID); err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenRevoked
+}
+
+// saveRefreshToken creates a new refresh token of a family, storing its
+// record
+func (e *Engine) saveRefreshToken(
+	familyID string, familyCreatedAt time.Time, subject string, sharedSecret string) (string, error) {
+	id, err := generateRefreshID()
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := utils.GenerateSalt(refreshTokenSecretLen)
+	if err != nil {
+		return "", err
+	}
+
+	secretHash, err := hash.CryptWithParams(secret, refreshTokenHashParams)
+	if err != nil {
+		return "", err
+	}
+
+	encryptedSharedSecret, err := cryptico.EncryptWithAD(
+		[]byte(sharedSecret), []byte(id), deriveRefreshTokenKey(secret))
+	if err != nil {
+		return "", err
+	}
+
+	err = e.RefreshTokens.Save(&RefreshRecord{
+		ID:                    id,
+		FamilyID:              familyID,
+		FamilyCreatedAt:       familyCreatedAt,
+		Subject:               subject,
+		SecretHash:            secretHash,
+		EncryptedSharedSecret: encryptedSharedSecret,
+		ExpiresAt:             e.NowFunc().Add(e.RefreshDuration),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// deriveRefreshTokenKey derives the key encrypting the shared secret from
+// the secret of a refresh token
+func deriveRefreshTokenKey(secret []byte) []byte {
+	key := make([]byte, refreshTokenKeyLen)
+	_, _ = io.ReadFull(hkdf.New(sha256.New, secret, nil, refreshTokenKeyInfo), key)
+	return key
+}
+
+// generateRefreshID generates a random ID for a refresh token or a family
+func generateRefreshID() (string, error) {
+	id, err := utils.GenerateSalt(refreshTokenIDLen)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// MemoryRefreshStore is a RefreshStore keeping the refresh tokens in
+// memory. The refresh tokens of a family are forgotten, when new ones are
+// saved, after all of them have expired.
+// It is safe for concurrent use, but it can't be shared among many
+// processes.
+type MemoryRefreshStore struct {
+	// The function to use to extract the current timestamp, stored here since
+	// it's useful to inject a mock one during the unit tests
+	NowFunc func() time.Time
+
+	mutex           sync.Mutex
+	records         map[string]*RefreshRecord
+	used            map[string]bool
+	revokedFamilies map[string]time.Time
+}
+
+// NewMemoryRefreshStore creates an empty MemoryRefreshStore
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		NowFunc:         time.Now,
+		records:         make(map[string]*RefreshRecord),
+		used:            make(map[string]bool),
+		revokedFamilies: make(map[string]time.Time),
+	}
+}
+
+// Save stores a new refresh token
+func (store *MemoryRefreshStore) Save(record *RefreshRecord) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.purge()
+	copied := *record
+	store.records[record.ID] = &copied
+	return nil
+}
+
+// Get gets a refresh token
+func (store *MemoryRefreshStore) Get(id string) (*RefreshRecord, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	record, ok := store.records[id]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	copied := *record
+	return &copied, nil
+}
+
+// Use marks a refresh token as used, returning false if it was already used
+func (store *MemoryRefreshStore) Use(id string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.records[id]; !ok {
+		return false, ErrRefreshTokenNotFound
+	}
+
+	if store.used[id] {
+		return false, nil
+	}
+
+	store.used[id] = true
+	return true, nil
+}
+
+// RevokeFamily revokes all the refresh tokens of a family
+func (store *MemoryRefreshStore) RevokeFamily(familyID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// The revocation is kept until every token of the family expires
+	var until time.Time
+	for _, record := range store.records {
+		if record.FamilyID == familyID && record.ExpiresAt.After(until) {
+			until = record.ExpiresAt
+		}
+	}
+
+	store.revokedFamilies[familyID] = until
+	return nil
+}
+
+// IsFamilyRevoked check if a family has been revoked
+func (store *MemoryRefreshStore) IsFamilyRevoked(familyID string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, ok := store.revokedFamilies[familyID]
+	return ok, nil
+}
+
+// purge forgets the expired families and revocations. The caller must hold
+// the mutex
+func (store *MemoryRefreshStore) purge() {
+	now := store.NowFunc()
+
+	// An expired refresh token is kept while its family is alive, so that
+	// its reuse is still detected
+	familyExpiresAt := make(map[string]time.Time)
+	for _, record := range store.records {
+		if record.ExpiresAt.After(familyExpiresAt[record.FamilyID]) {
+			familyExpiresAt[record.FamilyID] = record.ExpiresAt
+		}
+	}
+
+	for id, record := range store.records {
+		if !now.Before(familyExpiresAt[record.FamilyID]) {
+			delete(store.records, id)
+			delete(store.used, id)
+		}
+	}
+
+	for familyID, until := range store.revokedFamilies {
+		if !now.Before(until) {
+			delete(store.revokedFamilies, familyID)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package jwt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createTestRefreshEngine(t *testing.T, now *time.Time) (*Engine, *MemoryRefreshStore) {
+	store := NewMemoryRefreshStore()
+	store.NowFunc = func() time.Time {
+		return *now
+	}
+
+	engine := createTestClaimsEngine(t, now)
+	engine.RefreshTokens = store
+	engine.RefreshDuration = 24 * time.Hour
+	return engine, store
+}
+
+func TestRefreshJWT(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine, _ := createTestRefreshEngine(t, &now)
+
+	accessToken, refreshToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = engine.ParseJWT(accessToken); err != nil {
+		t.Fatal("Cannot decode token", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err = engine.ParseJWT(accessToken); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expired token accepted: %v", err)
+	}
+
+	newAccessToken, newRefreshToken, err := engine.RefreshJWT(refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newRefreshToken == refreshToken {
+		t.Error("Refresh token not rotated")
+	}
+
+	claims, err := engine.ParseJWT(newAccessToken)
+	if err != nil {
+		t.Fatal("Cannot decode token", err)
+	}
+
+	if claims.Subject != "myself" || claims.SharedSecret != "mygreatpassword" {
+		t.Errorf("Wrong refreshed claims: %v %v", claims.Subject, claims.SharedSecret)
+	}
+
+	if _, _, err = engine.RefreshJWT(newRefreshToken); err != nil {
+		t.Error("Cannot refresh token", err)
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine, _ := createTestRefreshEngine(t, &now)
+
+	_, stolenToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, legitToken, err := engine.RefreshJWT(stolenToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = engine.RefreshJWT(stolenToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Reuse not detected: %v", err)
+	}
+
+	// The whole family is revoked, including the latest token
+	if _, _, err = engine.RefreshJWT(legitToken); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Errorf("Family not revoked: %v", err)
+	}
+
+	// Other sessions are not affected
+	_, otherToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = engine.RefreshJWT(otherToken); err != nil {
+		t.Error("Cannot refresh token", err)
+	}
+}
+
+func TestRefreshTokenReuseAfterExpiry(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine, _ := createTestRefreshEngine(t, &now)
+
+	_, stolenToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(20 * time.Hour)
+	_, legitToken, err := engine.RefreshJWT(stolenToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stolen token has expired, but the session is still alive. Saving
+	// another session must not forget the stolen token
+	now = now.Add(5 * time.Hour)
+	if _, _, err = engine.CreateRefreshableJWT("someoneelse", "mygreatpassword"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = engine.RefreshJWT(stolenToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Reuse of expired token not detected: %v", err)
+	}
+
+	if _, _, err = engine.RefreshJWT(legitToken); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Errorf("Family not revoked: %v", err)
+	}
+}
+
+func TestRefreshTokenSubjectRevoked(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine, _ := createTestRefreshEngine(t, &now)
+	revocations := NewMemoryRevocationStore()
+	revocations.NowFunc = func() time.Time {
+		return now
+	}
+	engine.Revocations = revocations
+
+	_, stolenToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	if _, stolenToken, err = engine.RefreshJWT(stolenToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// The device is stolen. The revocation must outlive the access tokens,
+	// since the refresh tokens last longer
+	now = now.Add(time.Minute)
+	if err = engine.RevokeSubject("myself", now); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Second)
+	_, newToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, _, err = engine.RefreshJWT(stolenToken); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Errorf("Refresh token of a revoked subject accepted: %v", err)
+	}
+
+	if _, _, err = engine.RefreshJWT(newToken); err != nil {
+		t.Error("Cannot refresh token", err)
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine, _ := createTestRefreshEngine(t, &now)
+
+	_, refreshToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every refresh extends the session
+	now = now.Add(20 * time.Hour)
+	if _, refreshToken, err = engine.RefreshJWT(refreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(20 * time.Hour)
+	if _, refreshToken, err = engine.RefreshJWT(refreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(25 * time.Hour)
+	if _, _, err = engine.RefreshJWT(refreshToken); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Errorf("Expired refresh token accepted: %v", err)
+	}
+}
+
+func TestRefreshTokenInvalid(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine, _ := createTestRefreshEngine(t, &now)
+
+	_, refreshToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(refreshToken, ".")
+	_, otherToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []string{
+		"",
+		"garbage",
+		parts[0],
+		parts[0] + ".!!!",
+		parts[0] + "." + strings.Split(otherToken, ".")[1],
+		"unknown." + parts[1],
+	}
+
+	for _, test := range tests {
+		if _, _, err = engine.RefreshJWT(test); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("Invalid refresh token %q accepted: %v", test, err)
+		}
+	}
+
+	// The refresh token is still valid, since it has not been used
+	if _, _, err = engine.RefreshJWT(refreshToken); err != nil {
+		t.Error("Cannot refresh token", err)
+	}
+}
+
+func TestRefreshStoreSecrets(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine, store := createTestRefreshEngine(t, &now)
+
+	_, refreshToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := store.Get(strings.Split(refreshToken, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte(strings.Split(refreshToken, ".")[1])
+	if bytes.Contains(record.SecretHash, secret) ||
+		bytes.Contains(record.EncryptedSharedSecret, []byte("mygreatpassword")) {
+		t.Error("Plaintext secrets stored")
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine, _ := createTestRefreshEngine(t, &now)
+
+	_, refreshToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = engine.RevokeRefreshToken(refreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = engine.RefreshJWT(refreshToken); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Errorf("Revoked refresh token accepted: %v", err)
+	}
+
+	// Revoking twice is not an error
+	if err = engine.RevokeRefreshToken(refreshToken); err != nil {
+		t.Error("Cannot revoke refresh token", err)
+	}
+}
+
+func TestMemoryRefreshStorePurge(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine, store := createTestRefreshEngine(t, &now)
+
+	_, refreshToken, err := engine.CreateRefreshableJWT("myself", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = engine.RevokeRefreshToken(refreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// The expired tokens and revocations are purged on the next save
+	now = now.Add(25 * time.Hour)
+	if _, _, err = engine.CreateRefreshableJWT("myself", "mygreatpassword"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.records) != 1 || len(store.used) != 0 || len(store.revokedFamilies) != 0 {
+		t.Errorf("Expired refresh tokens not purged: %v %v", store.records, store.revokedFamilies)
+	}
+}
+
+func TestRefreshWithoutStore(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine := createTestClaimsEngine(t, &now)
+
+	if _, _, err := engine.CreateRefreshableJWT("myself", "mygreatpassword"); err == nil {
+		t.Error("Refresh token created without a store")
+	}
+
+	if _, _, err := engine.RefreshJWT("id.secret"); err == nil {
+		t.Error("Refresh token used without a store")
+	}
+}
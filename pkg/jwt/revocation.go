@@ -0,0 +1,220 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// revocationPurgeInterval is the minimum interval between two purges of
+	// the expired revocations
+	revocationPurgeInterval = time.Minute
+)
+
+var (
+	// ErrTokenRevoked is returned when parsing a revoked token
+	ErrTokenRevoked = errors.New("token is revoked")
+
+	// ErrRevocationCheckFailed is returned when the revocation store can't
+	// be consulted. Unlike the other errors returned by ParseJWT, this is not
+	// a problem of the token
+	ErrRevocationCheckFailed = errors.New("cannot check revocation")
+)
+
+// RevocationStore stores the revoked tokens, which are rejected by ParseJWT
+// until they expire. Tokens can be revoked one by one, via their ID, or in
+// bulk, via their subject and issue time.
+type RevocationStore interface {
+	// Revoke revokes the token with the passed ID. The revocation can be
+	// forgotten after `until`, when the token is expired
+	Revoke(jti string, until time.Time) error
+
+	// IsRevoked check if the token with the passed ID is revoked
+	IsRevoked(jti string) (bool, error)
+
+	// RevokeSubject revokes all the tokens of the passed subject issued
+	// before `before`. The revocation can be forgotten after `until`, when
+	// every token issued before `before` is expired
+	RevokeSubject(subject string, before time.Time, until time.Time) error
+
+	// IsSubjectRevoked check if the tokens of the passed subject issued at
+	// the passed time are revoked
+	IsSubjectRevoked(subject string, issuedAt time.Time) (bool, error)
+}
+
+// subjectRevocation is a bulk revocation of the tokens of a subject
+type subjectRevocation struct {
+	before time.Time
+	until  time.Time
+}
+
+// MemoryRevocationStore is a RevocationStore keeping the revocations in
+// memory. The expired revocations are periodically forgotten. It is safe for
+// concurrent use, but it can't be shared among many processes.
+type MemoryRevocationStore struct {
+	// The function to use to extract the current timestamp, stored here since
+	// it's useful to inject a mock one during the unit tests
+	NowFunc func() time.Time
+
+	mutex    sync.RWMutex
+	tokens   map[string]time.Time
+	subjects map[string]subjectRevocation
+	purgedAt time.Time
+}
+
+// NewMemoryRevocationStore creates an empty MemoryRevocationStore
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		NowFunc:  time.Now,
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]subjectRevocation),
+	}
+}
+
+// Revoke revokes the token with the passed ID until the passed time
+func (store *MemoryRevocationStore) Revoke(jti string, until time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if current, ok := store.tokens[jti]; !ok || until.After(current) {
+		store.tokens[jti] = until
+	}
+
+	store.purge()
+	return nil
+}
+
+// IsRevoked check if the token with the passed ID is revoked
+func (store *MemoryRevocationStore) IsRevoked(jti string) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	until, ok := store.tokens[jti]
+	return ok && store.NowFunc().Before(until), nil
+}
+
+// RevokeSubject revokes all the tokens of the passed subject issued before
+// the passed time
+func (store *MemoryRevocationStore) RevokeSubject(subject string, before time.Time, until time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	revocation := store.subjects[subject]
+	if before.After(revocation.before) {
+		revocation.before = before
+	}
+	if until.After(revocation.until) {
+		revocation.until = until
+	}
+	store.subjects[subject] = revocation
+
+	store.purge()
+	return nil
+}
+
+// IsSubjectRevoked check if the tokens of the passed subject issued at the
+// passed time are revoked
+func (store *MemoryRevocationStore) IsSubjectRevoked(subject string, issuedAt time.Time) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	revocation, ok := store.subjects[subject]
+	return ok && store.NowFunc().Before(revocation.until) && issuedAt.Before(revocation.before), nil
+}
+
+// purge forgets the expired revocations, at most once every
+// revocationPurgeInterval. The caller must hold the mutex
+func (store *MemoryRevocationStore) purge() {
+	now := store.NowFunc()
+	if now.Sub(store.purgedAt) < revocationPurgeInterval {
+		return
+	}
+	store.purgedAt = now
+
+	for jti, until := range store.tokens {
+		if !now.Before(until) {
+			delete(store.tokens, jti)
+		}
+	}
+
+	for subject, revocation := range store.subjects {
+		if !now.Before(revocation.until) {
+			delete(store.subjects, subject)
+		}
+	}
+}
+
+// RevokeJWT revokes a token, given its claims. The revocation is kept until
+// the token expires
+func (e *Engine) RevokeJWT(claims *CustomClaims) error {
+	if e.Revocations == nil {
+		return fmt.Errorf("RevokeJWT: no revocation store")
+	}
+
+	if claims.Id == "" {
+		return fmt.Errorf("RevokeJWT: token without ID")
+	}
+
+	until := time.Unix(claims.ExpiresAt, 0).Add(e.Leeway)
+	if err := e.Revocations.Revoke(claims.Id, until); err != nil {
+		return fmt.Errorf("RevokeJWT: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeSubject revokes all the tokens of a subject issued before the passed
+// time, for example after a password change or when a device is stolen. The
+// issue time of the tokens has a resolution of one second, so the tokens
+// issued in the same second of `before` are not revoked. The sessions with a
+// refresh token created before `before` are revoked too, when refreshed. The
+// revocation is kept for TokenDuration or RefreshDuration, whichever is
+// longer, and TokenDuration must be set on verifier engines too.
+func (e *Engine) RevokeSubject(subject string, before time.Time) error {
+	if e.Revocations == nil {
+		return fmt.Errorf("RevokeSubject: no revocation store")
+	}
+
+	duration := e.TokenDuration
+	if e.RefreshDuration > duration {
+		duration = e.RefreshDuration
+	}
+
+	before = before.Truncate(time.Second)
+	until := before.Add(duration + e.Leeway + time.Second)
+	if err := e.Revocations.RevokeSubject(subject, before, until); err != nil {
+		return fmt.Errorf("RevokeSubject: %w", err)
+	}
+
+	return nil
+}
+
+// checkRevocation check if a token has been revoked, directly or through
+// its subject
+func (e *Engine) checkRevocation(claims *CustomClaims) error {
+	if e.Revocations == nil {
+		return nil
+	}
+
+	if claims.Id != "" {
+		revoked, err := e.Revocations.IsRevoked(claims.Id)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrRevocationCheckFailed, err)
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+
+	revoked, err := e.Revocations.IsSubjectRevoked(claims.Subject, time.Unix(claims.IssuedAt, 0))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRevocationCheckFailed, err)
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
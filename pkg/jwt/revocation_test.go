@@ -0,0 +1,276 @@
+package jwt
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createTestRevocationEngine(t *testing.T, now *time.Time, store RevocationStore) *Engine {
+	engine := createTestClaimsEngine(t, now)
+	engine.Revocations = store
+	return engine
+}
+
+func createTestTokenClaims(t *testing.T, engine *Engine, subject string) (string, *CustomClaims) {
+	tokenString, err := engine.CreateJWT(subject, "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := engine.ParseJWT(tokenString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tokenString, claims
+}
+
+func testRevokeJWT(t *testing.T, store RevocationStore, now *time.Time) {
+	engine := createTestRevocationEngine(t, now, store)
+
+	tokenString, claims := createTestTokenClaims(t, engine, "myself")
+	otherToken, _ := createTestTokenClaims(t, engine, "myself")
+
+	if err := engine.RevokeJWT(claims); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.ParseJWT(tokenString); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Revoked token accepted: %v", err)
+	}
+
+	if _, err := engine.ParseJWT(otherToken); err != nil {
+		t.Error("Cannot decode token", err)
+	}
+}
+
+func testRevokeSubject(t *testing.T, store RevocationStore, now *time.Time) {
+	engine := createTestRevocationEngine(t, now, store)
+
+	oldToken, _ := createTestTokenClaims(t, engine, "myself")
+	otherSubjectToken, _ := createTestTokenClaims(t, engine, "somebody")
+
+	*now = now.Add(time.Minute)
+	if err := engine.RevokeSubject("myself", *now); err != nil {
+		t.Fatal(err)
+	}
+	newToken, _ := createTestTokenClaims(t, engine, "myself")
+
+	if _, err := engine.ParseJWT(oldToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Revoked token accepted: %v", err)
+	}
+
+	for _, tokenString := range []string{newToken, otherSubjectToken} {
+		if _, err := engine.ParseJWT(tokenString); err != nil {
+			t.Error("Cannot decode token", err)
+		}
+	}
+}
+
+func TestMemoryRevocationStore(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	store := NewMemoryRevocationStore()
+	store.NowFunc = func() time.Time {
+		return now
+	}
+
+	testRevokeJWT(t, store, &now)
+	testRevokeSubject(t, store, &now)
+}
+
+func TestMemoryRevocationStoreExpiry(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	store := NewMemoryRevocationStore()
+	store.NowFunc = func() time.Time {
+		return now
+	}
+
+	if err := store.Revoke("token", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSubject("myself", now, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if revoked, _ := store.IsRevoked("token"); revoked {
+		t.Error("Expired revocation still valid")
+	}
+	if revoked, _ := store.IsSubjectRevoked("myself", now.Add(-3*time.Hour)); revoked {
+		t.Error("Expired subject revocation still valid")
+	}
+
+	// The expired revocations are purged on the next revocation
+	if err := store.Revoke("another", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.tokens) != 1 || len(store.subjects) != 0 {
+		t.Errorf("Expired revocations not purged: %v %v", store.tokens, store.subjects)
+	}
+}
+
+func TestRevokeWithoutStore(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine := createTestClaimsEngine(t, &now)
+	_, claims := createTestTokenClaims(t, engine, "myself")
+
+	if err := engine.RevokeJWT(claims); err == nil {
+		t.Error("Token revoked without a store")
+	}
+
+	if err := engine.RevokeSubject("myself", now); err == nil {
+		t.Error("Subject revoked without a store")
+	}
+}
+
+func createTestRevocationFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "revocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "revocations.jsonl"), func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func openTestFileRevocationStore(t *testing.T, path string, now *time.Time) *FileRevocationStore {
+	store, err := OpenFileRevocationStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.NowFunc = func() time.Time {
+		return *now
+	}
+	return store
+}
+
+func TestFileRevocationStore(t *testing.T) {
+	path, cleanup := createTestRevocationFile(t)
+	defer cleanup()
+
+	now := time.Unix(1600000000, 0)
+	store := openTestFileRevocationStore(t, path, &now)
+	defer store.Close()
+
+	testRevokeJWT(t, store, &now)
+	testRevokeSubject(t, store, &now)
+}
+
+func TestFileRevocationStoreReopen(t *testing.T) {
+	path, cleanup := createTestRevocationFile(t)
+	defer cleanup()
+
+	now := time.Now()
+	store := openTestFileRevocationStore(t, path, &now)
+	engine := createTestRevocationEngine(t, &now, store)
+
+	tokenString, claims := createTestTokenClaims(t, engine, "myself")
+	if err := engine.RevokeJWT(claims); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RevokeSubject("somebody", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openTestFileRevocationStore(t, path, &now)
+	defer store.Close()
+	engine.Revocations = store
+
+	if _, err := engine.ParseJWT(tokenString); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Revocation lost: %v", err)
+	}
+
+	if revoked, _ := store.IsSubjectRevoked("somebody", now.Add(-time.Minute)); !revoked {
+		t.Error("Subject revocation lost")
+	}
+}
+
+func TestFileRevocationStoreCompact(t *testing.T) {
+	path, cleanup := createTestRevocationFile(t)
+	defer cleanup()
+
+	now := time.Now()
+	store := openTestFileRevocationStore(t, path, &now)
+	defer store.Close()
+
+	if err := store.Revoke("expiring", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke("lasting", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := store.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// New revocations are appended to the compacted file
+	if err := store.Revoke("new", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := string(data)
+	if strings.Contains(content, "expiring") || !strings.Contains(content, "lasting") ||
+		!strings.Contains(content, "new") {
+		t.Errorf("Wrong compacted file: %v", content)
+	}
+}
+
+func TestFileRevocationStoreTruncated(t *testing.T) {
+	path, cleanup := createTestRevocationFile(t)
+	defer cleanup()
+
+	until := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	content := `{"type":"token","id":"first","until":` + until + "}\n" + `{"type":"token","id":"sec`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	store := openTestFileRevocationStore(t, path, &now)
+	if revoked, _ := store.IsRevoked("first"); !revoked {
+		t.Error("Revocation lost")
+	}
+
+	if err := store.Revoke("third", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+
+	store = openTestFileRevocationStore(t, path, &now)
+	defer store.Close()
+	if revoked, _ := store.IsRevoked("third"); !revoked {
+		t.Error("Revocation lost")
+	}
+}
+
+func TestFileRevocationStoreInvalid(t *testing.T) {
+	path, cleanup := createTestRevocationFile(t)
+	defer cleanup()
+
+	content := "this is not a revocation\n" + `{"type":"token","id":"first","until":1}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenFileRevocationStore(path); err == nil {
+		t.Error("Invalid revocation file accepted")
+	}
+}
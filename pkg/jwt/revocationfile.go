@@ -0,0 +1,275 @@
+package jwt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+FileRevocationStore keeps the revocations in a file, one JSON record per
+line:
+
+	{"type":"token","id":"<jti>","until":1600000000}
+	{"type":"subject","id":"<subject>","before":1600000000,"until":1600003600}
+
+New revocations are appended to the file, which is compacted by Compact,
+dropping the expired ones.
+*/
+
+const (
+	revocationRecordToken   = "token"
+	revocationRecordSubject = "subject"
+
+	revocationFileMode = 0600
+)
+
+// revocationRecord is a line of a revocation file
+type revocationRecord struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Before int64  `json:"before,omitempty"`
+	Until  int64  `json:"until"`
+}
+
+// FileRevocationStore is a RevocationStore persisting the revocations in a
+// file, so they survive a restart. The revocations are also kept in memory,
+// so the file can't be shared among many processes. It is safe for
+// concurrent use.
+type FileRevocationStore struct {
+	*MemoryRevocationStore
+
+	fileMutex sync.Mutex
+	path      string
+	file      *os.File
+}
+
+// OpenFileRevocationStore opens the revocation file at the passed path,
+// creating it if it doesn't exist, and loads the revocations. The store must
+// be closed after use.
+func OpenFileRevocationStore(path string) (*FileRevocationStore, error) {
+	memoryStore := NewMemoryRevocationStore()
+	truncated, err := loadRevocationFile(path, memoryStore)
+	if err != nil {
+		return nil, fmt.Errorf("OpenFileRevocationStore: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, revocationFileMode)
+	if err != nil {
+		return nil, fmt.Errorf("OpenFileRevocationStore: %v", err)
+	}
+
+	store := &FileRevocationStore{
+		MemoryRevocationStore: memoryStore,
+		path:                  path,
+		file:                  file,
+	}
+
+	// The truncated line must be removed before appending new revocations
+	if truncated {
+		if err = store.Compact(); err != nil {
+			_ = store.Close()
+			return nil, fmt.Errorf("OpenFileRevocationStore: %v", err)
+		}
+	}
+
+	return store, nil
+}
+
+// Revoke revokes the token with the passed ID until the passed time
+func (store *FileRevocationStore) Revoke(jti string, until time.Time) error {
+	store.fileMutex.Lock()
+	defer store.fileMutex.Unlock()
+
+	err := store.append(revocationRecord{
+		Type:  revocationRecordToken,
+		ID:    jti,
+		Until: until.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return store.MemoryRevocationStore.Revoke(jti, until)
+}
+
+// RevokeSubject revokes all the tokens of the passed subject issued before
+// the passed time
+func (store *FileRevocationStore) RevokeSubject(subject string, before time.Time, until time.Time) error {
+	store.fileMutex.Lock()
+	defer store.fileMutex.Unlock()
+
+	err := store.append(revocationRecord{
+		Type:   revocationRecordSubject,
+		ID:     subject,
+		Before: before.Unix(),
+		Until:  until.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return store.MemoryRevocationStore.RevokeSubject(subject, before, until)
+}
+
+// Compact rewrites the revocation file without the expired revocations. The
+// file is replaced atomically, so a crash will leave the old file or the
+// new one
+func (store *FileRevocationStore) Compact() error {
+	store.fileMutex.Lock()
+	defer store.fileMutex.Unlock()
+
+	if store.file == nil {
+		return os.ErrClosed
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+	if err != nil {
+		return fmt.Errorf("Compact: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if err = store.writeRecords(temp); err != nil {
+		_ = temp.Close()
+		return fmt.Errorf("Compact: %v", err)
+	}
+
+	if err = temp.Close(); err != nil {
+		return fmt.Errorf("Compact: %v", err)
+	}
+
+	if err = os.Rename(temp.Name(), store.path); err != nil {
+		return fmt.Errorf("Compact: %v", err)
+	}
+
+	file, err := os.OpenFile(store.path, os.O_WRONLY|os.O_APPEND, revocationFileMode)
+	if err != nil {
+		return fmt.Errorf("Compact: %v", err)
+	}
+
+	_ = store.file.Close()
+	store.file = file
+	return nil
+}
+
+// Close closes the revocation file
+func (store *FileRevocationStore) Close() error {
+	store.fileMutex.Lock()
+	defer store.fileMutex.Unlock()
+
+	if store.file == nil {
+		return nil
+	}
+
+	err := store.file.Close()
+	store.file = nil
+	return err
+}
+
+// append writes a record to the revocation file, syncing it to the disk.
+// The caller must hold the file mutex, which is kept while updating the
+// memory store too, so Compact can't miss a revocation
+func (store *FileRevocationStore) append(record revocationRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if store.file == nil {
+		return os.ErrClosed
+	}
+
+	if _, err = store.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return store.file.Sync()
+}
+
+// writeRecords writes the revocations not yet expired to a new file,
+// syncing it to the disk
+func (store *FileRevocationStore) writeRecords(file *os.File) error {
+	now := store.NowFunc()
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+
+	store.MemoryRevocationStore.mutex.RLock()
+	defer store.MemoryRevocationStore.mutex.RUnlock()
+
+	for jti, until := range store.tokens {
+		if !now.Before(until) {
+			continue
+		}
+
+		err := encoder.Encode(revocationRecord{Type: revocationRecordToken, ID: jti, Until: until.Unix()})
+		if err != nil {
+			return err
+		}
+	}
+
+	for subject, revocation := range store.subjects {
+		if !now.Before(revocation.until) {
+			continue
+		}
+
+		err := encoder.Encode(revocationRecord{
+			Type:   revocationRecordSubject,
+			ID:     subject,
+			Before: revocation.before.Unix(),
+			Until:  revocation.until.Unix(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// loadRevocationFile loads the revocations of a file in a memory store. A
+// missing file is considered empty, and a truncated last line, caused by a
+// crash while writing it, is ignored and reported
+func loadRevocationFile(path string, store *MemoryRevocationStore) (bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var record revocationRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			if !scanner.Scan() && scanner.Err() == nil {
+				return true, nil
+			}
+			return false, fmt.Errorf("invalid revocation at line %v", line)
+		}
+
+		switch record.Type {
+		case revocationRecordToken:
+			err = store.Revoke(record.ID, time.Unix(record.Until, 0))
+		case revocationRecordSubject:
+			err = store.RevokeSubject(record.ID, time.Unix(record.Before, 0), time.Unix(record.Until, 0))
+		default:
+			err = fmt.Errorf("invalid revocation at line %v", line)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,30 @@
+package jwt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Mind-Informatica-srl/idcrypt/pkg/idcrypt"
+)
+
+// CreateSessionJWT creates a new session for the master key, see
+// idcrypt.NewSession, and a token for it. The session ID is stored in the
+// `sub` claim and the shared secret in the `sharedSecret` claim. The session
+// ID and the returned CredentialRecord should be stored in the database, so
+// the master key can be recovered with idcrypt.OpenSession when the token is
+// received back. The session expires after the passed duration, measured
+// with NowFunc, which should not be shorter than TokenDuration.
+func (e *Engine) CreateSessionJWT(
+	masterKey []byte, ttl time.Duration) (string, string, *idcrypt.CredentialRecord, error) {
+	sessionID, sharedSecret, record, err := idcrypt.NewSessionAt(masterKey, ttl, e.NowFunc())
+	if err != nil {
+		return "", "", nil, fmt.Errorf("CreateSessionJWT: %w", err)
+	}
+
+	tokenString, err := e.CreateJWT(sessionID, sharedSecret)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("CreateSessionJWT: %w", err)
+	}
+
+	return tokenString, sessionID, record, nil
+}
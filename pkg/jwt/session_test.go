@@ -0,0 +1,54 @@
+package jwt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Mind-Informatica-srl/idcrypt/pkg/idcrypt"
+)
+
+func TestCreateSessionJWT(t *testing.T) {
+	masterKey := []byte("this is my master key,  is nice?")
+	engine, err := createTestEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, sessionID, record, err := engine.CreateSessionJWT(masterKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := engine.ParseJWT(tokenString)
+	if err != nil {
+		t.Fatal("Cannot decode token", err)
+	}
+
+	if claims.Subject != sessionID {
+		t.Errorf("Wrong subject: %v", claims.Subject)
+	}
+
+	recoveredKey, err := idcrypt.OpenSession(record, claims.SharedSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(recoveredKey, masterKey) {
+		t.Error("Wrong master key recovered")
+	}
+}
+
+func TestCreateSessionJWTClock(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	engine := createTestClaimsEngine(t, &now)
+
+	_, _, record, err := engine.CreateSessionJWT([]byte("this is my master key,  is nice?"), 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !record.ExpiresAt.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("Wrong expiry: %v", record.ExpiresAt)
+	}
+}
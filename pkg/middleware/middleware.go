@@ -0,0 +1,182 @@
+/*
+Package middleware implement a net/http middleware authenticating the
+requests with the tokens created by the jwt package.
+
+Every request must carry a token in the `Authorization` header, using the
+Bearer scheme defined in RFC 6750. The token is validated and the credential
+record of its subject, usually a session created by
+jwt.Engine.CreateSessionJWT, is looked up to recover the master key with the
+shared secret carried by the token. The master key and the claims are then
+stored in the context of the request, and can be extracted with MasterKey
+and Claims.
+
+Requests without a valid token are rejected with a 401 response carrying a
+`WWW-Authenticate` header, as required by RFC 6750.
+*/
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Mind-Informatica-srl/idcrypt/pkg/idcrypt"
+	"github.com/Mind-Informatica-srl/idcrypt/pkg/jwt"
+)
+
+const (
+	bearerScheme = "Bearer"
+
+	errorInvalidToken = "invalid_token"
+)
+
+var (
+	// ErrCredentialNotFound should be returned by a CredentialLookup when the
+	// credential record doesn't exist. The request is then rejected as
+	// unauthorized, while any other error is an internal server error
+	ErrCredentialNotFound = errors.New("credential not found")
+)
+
+// contextKey is the type of the keys of the values stored by the middleware
+// in the request context, so they can't collide with the ones of other
+// packages
+type contextKey int
+
+const (
+	masterKeyContextKey contextKey = iota
+	claimsContextKey
+)
+
+// CredentialLookup gets the credential record of the subject of a token,
+// usually the ID of a session. If the credential doesn't exist
+// ErrCredentialNotFound must be returned
+type CredentialLookup func(ctx context.Context, subject string) (*idcrypt.CredentialRecord, error)
+
+// Authenticator is the configuration of the middleware
+type Authenticator struct {
+	// The engine validating the tokens
+	Engine *jwt.Engine
+
+	// The function looking up the credential records
+	Lookup CredentialLookup
+
+	// The protection space, sent to the clients in the `realm` parameter of
+	// the `WWW-Authenticate` header. If empty the parameter is omitted
+	Realm string
+}
+
+// NewAuthenticator creates a new Authenticator validating the tokens with the
+// passed engine and looking up the credential records with the passed
+// function
+func NewAuthenticator(engine *jwt.Engine, lookup CredentialLookup) *Authenticator {
+	return &Authenticator{
+		Engine: engine,
+		Lookup: lookup,
+	}
+}
+
+// Middleware wraps an handler, which is called only for the authenticated
+// requests, with the master key and the claims stored in the request context
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString, ok := bearerToken(r)
+		if !ok {
+			a.unauthorized(w, "", "")
+			return
+		}
+
+		claims, err := a.Engine.ParseJWT(tokenString)
+		if errors.Is(err, jwt.ErrRevocationCheckFailed) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			a.unauthorized(w, errorInvalidToken, "the token is not valid")
+			return
+		}
+
+		masterKey, err := a.recoverMasterKey(r.Context(), claims)
+		if errors.Is(err, ErrCredentialNotFound) || errors.Is(err, idcrypt.ErrSessionExpired) ||
+			errors.Is(err, idcrypt.ErrAuthenticationFailed) {
+			a.unauthorized(w, errorInvalidToken, "the session is not valid")
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), masterKeyContextKey, masterKey)
+		ctx = context.WithValue(ctx, claimsContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// recoverMasterKey looks up the credential record of the subject of a token
+// and recovers the master key with its shared secret. The expiry of the
+// sessions is enforced with the clock of the engine
+func (a *Authenticator) recoverMasterKey(ctx context.Context, claims *jwt.CustomClaims) ([]byte, error) {
+	record, err := a.Lookup(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if record == nil {
+		return nil, ErrCredentialNotFound
+	}
+
+	if !record.ExpiresAt.IsZero() {
+		return idcrypt.OpenSessionAt(record, claims.SharedSecret, a.Engine.NowFunc())
+	}
+
+	return record.RecoverMasterKey(claims.SharedSecret)
+}
+
+// unauthorized rejects a request with a 401 response. The error code and its
+// description are omitted from the `WWW-Authenticate` header when the request
+// has no token
+func (a *Authenticator) unauthorized(w http.ResponseWriter, errorCode string, description string) {
+	var params []string
+	if a.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", a.Realm))
+	}
+	if errorCode != "" {
+		params = append(params, fmt.Sprintf("error=%q", errorCode))
+		params = append(params, fmt.Sprintf("error_description=%q", description))
+	}
+
+	challenge := bearerScheme
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// bearerToken extracts the token from the `Authorization` header of a
+// request. The scheme is case insensitive
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], bearerScheme) {
+		return "", false
+	}
+
+	tokenString := strings.TrimSpace(parts[1])
+	return tokenString, tokenString != ""
+}
+
+// MasterKey gets the master key stored in the context by the middleware
+func MasterKey(ctx context.Context) ([]byte, bool) {
+	masterKey, ok := ctx.Value(masterKeyContextKey).([]byte)
+	return masterKey, ok
+}
+
+// Claims gets the claims of the token stored in the context by the
+// middleware
+func Claims(ctx context.Context) (*jwt.CustomClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*jwt.CustomClaims)
+	return claims, ok
+}
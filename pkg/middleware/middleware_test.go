@@ -0,0 +1,303 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mind-Informatica-srl/idcrypt/pkg/idcrypt"
+	"github.com/Mind-Informatica-srl/idcrypt/pkg/jwt"
+)
+
+var (
+	masterKey = []byte("this is my master key,  is nice?")
+)
+
+func createTestEngine(t *testing.T) *jwt.Engine {
+	privateKey, err := ioutil.ReadFile("../jwt/testdata/ed25519.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := ioutil.ReadFile("../jwt/testdata/ed25519.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := jwt.CreateEngineWithAlgorithm(jwt.AlgorithmEdDSA, privateKey, publicKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return engine
+}
+
+// createTestAuthenticator creates an authenticator looking up the sessions in
+// a map, and a server using it. The handler writes the master key and the
+// subject of the token
+func createTestAuthenticator(t *testing.T) (*Authenticator, map[string]*idcrypt.CredentialRecord, *httptest.Server) {
+	sessions := make(map[string]*idcrypt.CredentialRecord)
+	lookup := func(ctx context.Context, subject string) (*idcrypt.CredentialRecord, error) {
+		if subject == "broken" {
+			return nil, errors.New("database is down")
+		}
+
+		record, ok := sessions[subject]
+		if !ok {
+			return nil, ErrCredentialNotFound
+		}
+		return record, nil
+	}
+
+	authenticator := NewAuthenticator(createTestEngine(t), lookup)
+	authenticator.Realm = "documents"
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := MasterKey(r.Context())
+		if !ok {
+			t.Error("Master key not in context")
+		}
+
+		claims, ok := Claims(r.Context())
+		if !ok {
+			t.Error("Claims not in context")
+		}
+
+		_, _ = w.Write([]byte(claims.Subject + ":"))
+		_, _ = w.Write(key)
+	})
+
+	return authenticator, sessions, httptest.NewServer(authenticator.Middleware(handler))
+}
+
+func doTestRequest(t *testing.T, url string, authorization string) (*http.Response, string) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response, string(body)
+}
+
+func TestMiddleware(t *testing.T) {
+	authenticator, sessions, server := createTestAuthenticator(t)
+	defer server.Close()
+
+	tokenString, sessionID, record, err := authenticator.Engine.CreateSessionJWT(masterKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions[sessionID] = record
+
+	for _, scheme := range []string{"Bearer", "bearer"} {
+		response, body := doTestRequest(t, server.URL, scheme+" "+tokenString)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Request rejected: %v %v", response.Status, body)
+		}
+
+		if body != sessionID+":"+string(masterKey) {
+			t.Errorf("Wrong context: %v", body)
+		}
+	}
+}
+
+func TestMiddlewareUserCredential(t *testing.T) {
+	authenticator, sessions, server := createTestAuthenticator(t)
+	defer server.Close()
+
+	record, err := idcrypt.NewCredentialRecordWithPolicy("this is my password", masterKey, idcrypt.Policy{
+		PasswordHash: idcrypt.KDFParams{Algorithm: idcrypt.KDFPBKDF2SHA256, Iterations: 1},
+		MasterKey:    idcrypt.KDFParams{Algorithm: idcrypt.KDFPBKDF2SHA256, Iterations: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions["myself"] = record
+
+	tokenString, err := authenticator.Engine.CreateJWT("myself", "this is my password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, body := doTestRequest(t, server.URL, "Bearer "+tokenString)
+	if response.StatusCode != http.StatusOK || !bytes.HasSuffix([]byte(body), masterKey) {
+		t.Errorf("Request rejected: %v %v", response.Status, body)
+	}
+}
+
+func TestMiddlewareUnauthorized(t *testing.T) {
+	authenticator, sessions, server := createTestAuthenticator(t)
+	defer server.Close()
+
+	validToken, sessionID, record, err := authenticator.Engine.CreateSessionJWT(masterKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions[sessionID] = record
+
+	unknownToken, _, _, err := authenticator.Engine.CreateSessionJWT(masterKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongSecretToken, err := authenticator.Engine.CreateJWT(sessionID, strings.Repeat("0", 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shortSecretToken, err := authenticator.Engine.CreateJWT(sessionID, "short")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		authorization string
+		challenge     string
+	}{
+		{"", `Bearer realm="documents"`},
+		{"Basic dXNlcjpwYXNzd29yZA==", `Bearer realm="documents"`},
+		{"Bearer ", `Bearer realm="documents"`},
+		{"Bearer garbage", `Bearer realm="documents", error="invalid_token"`},
+		{"Bearer " + validToken + "x", `Bearer realm="documents", error="invalid_token"`},
+		{"Bearer " + unknownToken, `Bearer realm="documents", error="invalid_token"`},
+		{"Bearer " + wrongSecretToken, `Bearer realm="documents", error="invalid_token"`},
+		{"Bearer " + shortSecretToken, `Bearer realm="documents", error="invalid_token"`},
+	}
+
+	for _, test := range tests {
+		response, _ := doTestRequest(t, server.URL, test.authorization)
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Request with %q accepted: %v", test.authorization, response.Status)
+		}
+
+		challenge := response.Header.Get("WWW-Authenticate")
+		if !strings.HasPrefix(challenge, test.challenge) {
+			t.Errorf("Wrong challenge for %q: %v", test.authorization, challenge)
+		}
+	}
+}
+
+func TestMiddlewareSessionExpired(t *testing.T) {
+	authenticator, sessions, server := createTestAuthenticator(t)
+	defer server.Close()
+
+	// The session expires before the token
+	tokenString, sessionID, record, err := authenticator.Engine.CreateSessionJWT(masterKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.ExpiresAt = time.Now().Add(-time.Minute)
+	sessions[sessionID] = record
+
+	response, _ := doTestRequest(t, server.URL, "Bearer "+tokenString)
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expired session accepted: %v", response.Status)
+	}
+}
+
+func TestMiddlewareLookupError(t *testing.T) {
+	authenticator, _, server := createTestAuthenticator(t)
+	defer server.Close()
+
+	tokenString, err := authenticator.Engine.CreateJWT("broken", "mygreatpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, _ := doTestRequest(t, server.URL, "Bearer "+tokenString)
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Wrong status for lookup error: %v", response.Status)
+	}
+
+	if response.Header.Get("WWW-Authenticate") != "" {
+		t.Error("Challenge sent for lookup error")
+	}
+}
+
+// brokenRevocationStore is a RevocationStore which is always unavailable
+type brokenRevocationStore struct{}
+
+func (brokenRevocationStore) Revoke(jti string, until time.Time) error {
+	return errors.New("database is down")
+}
+
+func (brokenRevocationStore) IsRevoked(jti string) (bool, error) {
+	return false, errors.New("database is down")
+}
+
+func (brokenRevocationStore) RevokeSubject(subject string, before time.Time, until time.Time) error {
+	return errors.New("database is down")
+}
+
+func (brokenRevocationStore) IsSubjectRevoked(subject string, issuedAt time.Time) (bool, error) {
+	return false, errors.New("database is down")
+}
+
+func TestMiddlewareRevocationError(t *testing.T) {
+	authenticator, sessions, server := createTestAuthenticator(t)
+	defer server.Close()
+
+	tokenString, sessionID, record, err := authenticator.Engine.CreateSessionJWT(masterKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions[sessionID] = record
+
+	// A revoked token is rejected as invalid
+	claims, err := authenticator.Engine.ParseJWT(tokenString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator.Engine.Revocations = jwt.NewMemoryRevocationStore()
+	if err = authenticator.Engine.RevokeJWT(claims); err != nil {
+		t.Fatal(err)
+	}
+
+	response, _ := doTestRequest(t, server.URL, "Bearer "+tokenString)
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Wrong status for revoked token: %v", response.Status)
+	}
+
+	// An unavailable revocation store is not a problem of the token
+	authenticator.Engine.Revocations = brokenRevocationStore{}
+	response, _ = doTestRequest(t, server.URL, "Bearer "+tokenString)
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Wrong status for revocation error: %v", response.Status)
+	}
+
+	if response.Header.Get("WWW-Authenticate") != "" {
+		t.Error("Challenge sent for revocation error")
+	}
+}
+
+func TestContextWithoutMiddleware(t *testing.T) {
+	if _, ok := MasterKey(context.Background()); ok {
+		t.Error("Master key found in empty context")
+	}
+
+	if _, ok := Claims(context.Background()); ok {
+		t.Error("Claims found in empty context")
+	}
+}
@@ -0,0 +1,143 @@
+package otp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/xlzd/gotp"
+)
+
+const (
+	// DefaultLookAhead is the default number of counters after the expected
+	// one accepted by HOTP.Verify, since the button of the device can be
+	// pressed without logging in
+	DefaultLookAhead = 10
+
+	// DefaultResyncWindow is the default number of counters after the
+	// expected one searched by HOTP.Resync
+	DefaultResyncWindow = 100
+
+	// otpTypeHOTP is the type of the HOTP provisioning URIs
+	otpTypeHOTP = "hotp"
+)
+
+var (
+	// ErrInvalidCounter is returned when an HOTP counter is negative
+	ErrInvalidCounter = errors.New("invalid HOTP counter")
+)
+
+// HOTP represent an HOTP account, as defined by RFC 4226. The counter is not
+// stored here: it must be persisted for every account, and updated after
+// every successful verification
+type HOTP struct {
+	gotp.HOTP
+
+	// The number of counters after the expected one accepted by Verify
+	LookAhead int
+
+	// The number of counters after the expected one searched by Resync
+	ResyncWindow int
+
+	secret  string
+	options Options
+}
+
+// NewHOTP create a new HOTP engine with the passed options. `secret` is the
+// OTP device secret, base32 encoded, and is unique for every account. The
+// period of the options is not used
+func NewHOTP(secret string, options Options) (*HOTP, error) {
+	options = options.normalized()
+	hasher, err := options.hasher()
+	if err != nil {
+		return nil, fmt.Errorf("NewHOTP: %w", ErrInvalidOptions)
+	}
+
+	if err = checkSecret(secret); err != nil {
+		return nil, fmt.Errorf("NewHOTP: %w", err)
+	}
+
+	return &HOTP{
+		HOTP:         *gotp.NewHOTP(secret, options.Digits, hasher),
+		LookAhead:    DefaultLookAhead,
+		ResyncWindow: DefaultResyncWindow,
+		secret:       secret,
+		options:      options,
+	}, nil
+}
+
+// Generate gets the code of the passed counter, which must not be negative.
+// An empty code is returned for a negative counter
+func (hotp *HOTP) Generate(counter int64) string {
+	if counter < 0 {
+		return ""
+	}
+
+	return hotp.At(int(counter))
+}
+
+// Verify control is the passed `otp` is valid or not, given the expected
+// counter. The codes of the next LookAhead counters are accepted too. The new
+// counter, following the matched one, is returned and must be persisted. If
+// the code is not valid ErrInvalidCode is returned.
+func (hotp *HOTP) Verify(otp string, counter int64) (int64, error) {
+	if counter < 0 {
+		return counter, ErrInvalidCounter
+	}
+
+	cleanOtp := strings.ReplaceAll(otp, " ", "")
+	for current := counter; current <= counter+int64(hotp.LookAhead); current++ {
+		if hotp.matches(cleanOtp, current) {
+			return current + 1, nil
+		}
+	}
+
+	return counter, ErrInvalidCode
+}
+
+// Resync resynchronizes the counter of a device whose counter is beyond the
+// look-ahead window, given two consecutive codes generated by the device.
+// The next ResyncWindow counters after the expected one are searched, and
+// the new counter, following the one of the second code, is returned and
+// must be persisted. If the codes are not valid ErrInvalidCode is returned.
+func (hotp *HOTP) Resync(firstOtp string, secondOtp string, counter int64) (int64, error) {
+	if counter < 0 {
+		return counter, ErrInvalidCounter
+	}
+
+	cleanFirstOtp := strings.ReplaceAll(firstOtp, " ", "")
+	cleanSecondOtp := strings.ReplaceAll(secondOtp, " ", "")
+	for current := counter; current <= counter+int64(hotp.ResyncWindow); current++ {
+		if hotp.matches(cleanFirstOtp, current) && hotp.matches(cleanSecondOtp, current+1) {
+			return current + 2, nil
+		}
+	}
+
+	return counter, ErrInvalidCode
+}
+
+// ProvisioningUri gets the `otpauth://hotp/` URI that should be read by a
+// mobile device to create the account, starting from the passed counter. If
+// the account name or the issuer name are empty, the ones of the options are
+// used
+func (hotp *HOTP) ProvisioningUri(accountName string, issuerName string, counter int64) string {
+	params := url.Values{}
+	params.Set("counter", strconv.FormatInt(counter, 10))
+	return hotp.options.provisioningURI(otpTypeHOTP, hotp.secret, accountName, issuerName, params)
+}
+
+// GetQRCodeAsPNG create a new PNG file (256x256) with the QR code that should
+// be read by a mobile device to create the account, starting from the passed
+// counter
+func (hotp *HOTP) GetQRCodeAsPNG(accountName string, issuerName string, counter int64) ([]byte, error) {
+	return qrcode.Encode(hotp.ProvisioningUri(accountName, issuerName, counter), qrcode.Medium, 256)
+}
+
+// matches check if a code is the one of the passed counter, in constant time
+func (hotp *HOTP) matches(otp string, counter int64) bool {
+	return subtle.ConstantTimeCompare([]byte(hotp.Generate(counter)), []byte(otp)) == 1
+}
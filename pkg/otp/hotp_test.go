@@ -0,0 +1,143 @@
+package otp
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+var (
+	// The RFC 4226 test vectors, from appendix D, for the counters from 0
+	// to 9
+	rfc4226Codes = []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+)
+
+func createTestHOTP(t *testing.T) *HOTP {
+	hotp, err := NewHOTP(rfc6238Secrets[AlgorithmSHA1], Options{Issuer: "My Company", Account: "test.user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hotp
+}
+
+func TestRFC4226Vectors(t *testing.T) {
+	hotp := createTestHOTP(t)
+	for counter, expected := range rfc4226Codes {
+		if code := hotp.Generate(int64(counter)); code != expected {
+			t.Errorf("Wrong code for counter %v: %v instead of %v", counter, code, expected)
+		}
+	}
+
+	if code := hotp.Generate(-1); code != "" {
+		t.Errorf("Code generated for a negative counter: %v", code)
+	}
+}
+
+func TestHOTPVerify(t *testing.T) {
+	hotp := createTestHOTP(t)
+	hotp.LookAhead = 3
+
+	counter, err := hotp.Verify(rfc4226Codes[2], 2)
+	if err != nil || counter != 3 {
+		t.Errorf("Cannot verify code: %v %v", counter, err)
+	}
+
+	// The codes of the look-ahead window are accepted
+	counter, err = hotp.Verify(rfc4226Codes[6], counter)
+	if err != nil || counter != 7 {
+		t.Errorf("Cannot verify code in the window: %v %v", counter, err)
+	}
+
+	// The used codes are rejected
+	if counter, err = hotp.Verify(rfc4226Codes[6], counter); !errors.Is(err, ErrInvalidCode) || counter != 7 {
+		t.Errorf("Used code accepted: %v %v", counter, err)
+	}
+
+	if _, err = hotp.Verify(rfc4226Codes[2], 7); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Old code accepted: %v", err)
+	}
+
+	// The codes beyond the look-ahead window are rejected
+	if _, err = hotp.Verify(rfc4226Codes[9], 0); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Code outside of the window accepted: %v", err)
+	}
+
+	if _, err = hotp.Verify(rfc4226Codes[0], -1); !errors.Is(err, ErrInvalidCounter) {
+		t.Errorf("Negative counter accepted: %v", err)
+	}
+}
+
+func TestHOTPResync(t *testing.T) {
+	hotp := createTestHOTP(t)
+	hotp.LookAhead = 1
+
+	counter, err := hotp.Resync(rfc4226Codes[6], rfc4226Codes[7], 0)
+	if err != nil || counter != 8 {
+		t.Errorf("Cannot resync: %v %v", counter, err)
+	}
+
+	// The codes must be consecutive
+	if _, err = hotp.Resync(rfc4226Codes[6], rfc4226Codes[8], 0); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Resync with non consecutive codes: %v", err)
+	}
+
+	hotp.ResyncWindow = 5
+	if _, err = hotp.Resync(rfc4226Codes[6], rfc4226Codes[7], 0); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Resync outside of the window: %v", err)
+	}
+}
+
+func TestHOTPProvisioningUri(t *testing.T) {
+	hotp, err := NewHOTP(rfc6238Secrets[AlgorithmSHA1], Options{
+		Digits:    8,
+		Algorithm: AlgorithmSHA512,
+		Issuer:    "My Company",
+		Account:   "test.user@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri, err := url.Parse(hotp.ProvisioningUri("", "", 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "hotp" || uri.Path != "/My Company:test.user@example.com" {
+		t.Errorf("Wrong URI: %v", uri)
+	}
+
+	query := uri.Query()
+	expected := map[string]string{
+		"secret":    rfc6238Secrets[AlgorithmSHA1],
+		"issuer":    "My Company",
+		"algorithm": "SHA512",
+		"digits":    "8",
+		"counter":   "42",
+		"period":    "",
+	}
+	for name, value := range expected {
+		if query.Get(name) != value {
+			t.Errorf("Wrong %v: %v", name, query.Get(name))
+		}
+	}
+
+	png, err := hotp.GetQRCodeAsPNG("", "", 42)
+	if err != nil || len(png) == 0 {
+		t.Errorf("Cannot create QR code: %v", err)
+	}
+}
+
+func TestHOTPInvalidOptions(t *testing.T) {
+	if _, err := NewHOTP(rfc6238Secrets[AlgorithmSHA1], Options{Digits: 10}); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Invalid options accepted: %v", err)
+	}
+
+	if _, err := NewHOTP("not base32!", Options{}); !errors.Is(err, ErrInvalidSecret) {
+		t.Errorf("Invalid secret accepted: %v", err)
+	}
+}
@@ -5,29 +5,238 @@ implement the HOTP and TOPT standard
 package otp
 
 import (
+	"crypto/sha1" // #nosec G505, SHA-1 is the default algorithm of RFC 6238, used within HMAC
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	qrcode "github.com/skip2/go-qrcode"
 	"github.com/xlzd/gotp"
 )
 
+const (
+	// AlgorithmSHA1 is the HMAC-SHA1 algorithm, the default one, supported
+	// by every authenticator App
+	AlgorithmSHA1 = "SHA1"
+
+	// AlgorithmSHA256 is the HMAC-SHA256 algorithm
+	AlgorithmSHA256 = "SHA256"
+
+	// AlgorithmSHA512 is the HMAC-SHA512 algorithm
+	AlgorithmSHA512 = "SHA512"
+
+	// DefaultDigits is the default number of digits of the codes
+	DefaultDigits = 6
+
+	// DefaultPeriod is the default validity of a code, in seconds
+	DefaultPeriod = 30
+
+	// otpTypeTOTP is the type of the TOTP provisioning URIs
+	otpTypeTOTP = "totp"
+
+	// maxDigits is the maximum number of digits of the codes, since the
+	// dynamic truncation of RFC 4226 extracts 31 bits
+	maxDigits = 8
+)
+
+var (
+	// ErrInvalidOptions is returned when the OTP options are not valid
+	ErrInvalidOptions = errors.New("invalid OTP options")
+
+	// ErrInvalidSecret is returned when the OTP secret is not base32 encoded
+	ErrInvalidSecret = errors.New("invalid OTP secret")
+
+	// ErrInvalidCode is returned when an OTP code is not valid
+	ErrInvalidCode = errors.New("invalid OTP code")
+
+	// ErrCodeReused is returned when an OTP code has already been used
+	ErrCodeReused = errors.New("OTP code already used")
+)
+
+// Options are the parameters of an OTP account. The zero value of every
+// field is replaced by its default
+type Options struct {
+	// The number of digits of the codes, between 6 and 8. The default is 6
+	Digits int
+
+	// The validity of a code, in seconds. The default is 30. This is not
+	// used by HOTP
+	Period int
+
+	// The HMAC algorithm: AlgorithmSHA1, AlgorithmSHA256 or AlgorithmSHA512.
+	// The default is AlgorithmSHA1
+	Algorithm string
+
+	// The name of the OTP issuer, shown by the authenticator App, used when
+	// no issuer is passed to ProvisioningUri
+	Issuer string
+
+	// The name of the account, shown by the authenticator App, used when no
+	// account is passed to ProvisioningUri. It is also the key of the used
+	// codes, see TOTP.UsedCodes
+	Account string
+}
+
 // TOTP represent an TOTP account
 type TOTP struct {
 	gotp.TOTP
+
+	// The function to use to extract the current timestamp, stored here since
+	// it's useful to inject a mock one during the unit tests. Every code is
+	// generated and verified with this clock
+	NowFunc func() time.Time
+
+	// The store of the used codes, which can't be accepted twice. If this
+	// is nil the codes can be replayed while they are valid
+	UsedCodes UsedCodeStore
+
+	secret  string
+	options Options
 }
 
 // NewTOTP create a new TOTP engine. `secret` is the OTP device secret and is
 // unique for every account
 func NewTOTP(secret string) *TOTP {
 	return &TOTP{
-		*gotp.NewDefaultTOTP(secret),
+		TOTP:    *gotp.NewDefaultTOTP(secret),
+		NowFunc: time.Now,
+		secret:  secret,
+		options: Options{}.normalized(),
+	}
+}
+
+// NewTOTPWithOptions create a new TOTP engine with the passed options.
+// `secret` is the OTP device secret, base32 encoded, and is unique for every
+// account
+func NewTOTPWithOptions(secret string, options Options) (*TOTP, error) {
+	options = options.normalized()
+	hasher, err := options.hasher()
+	if err != nil || options.Period <= 0 {
+		return nil, fmt.Errorf("NewTOTPWithOptions: %w", ErrInvalidOptions)
+	}
+
+	if err = checkSecret(secret); err != nil {
+		return nil, fmt.Errorf("NewTOTPWithOptions: %w", err)
 	}
+
+	return &TOTP{
+		TOTP:    *gotp.NewTOTP(secret, options.Digits, options.Period, hasher),
+		NowFunc: time.Now,
+		secret:  secret,
+		options: options,
+	}, nil
+}
+
+// At gets the code of the time step containing the passed time
+func (totp *TOTP) At(t time.Time) string {
+	return totp.TOTP.At(int(t.Unix()))
+}
+
+// Now gets the code of the current time step
+func (totp *TOTP) Now() string {
+	return totp.At(totp.NowFunc())
 }
 
-// Verify control is the passed `otp` is valid or not
+// NowWithExpiration gets the code of the current time step and the Unix time
+// when it expires
+func (totp *TOTP) NowWithExpiration() (string, int64) {
+	now := totp.NowFunc()
+	period := int64(totp.options.Period)
+	return totp.At(now), (now.Unix()/period + 1) * period
+}
+
+// Verify control is the passed `otp` is valid or not. Only the code of the
+// current time step is accepted, see VerifyWithWindow
 func (totp *TOTP) Verify(otp string) bool {
-	cleanOtp := strings.ReplaceAll(otp, " ", "")
-	return totp.Now() == cleanOtp
+	return totp.VerifyAt(otp, totp.NowFunc())
+}
+
+// VerifyAt control is the passed `otp` is valid or not at the passed time.
+// Only the code of the time step containing the passed time is accepted
+func (totp *TOTP) VerifyAt(otp string, t time.Time) bool {
+	_, err := totp.verifyWithWindowAt(otp, 0, t)
+	return err == nil
+}
+
+// VerifyWithWindow control is the passed `otp` is valid or not, accepting
+// the codes of `steps` time steps before and after the current one, to
+// tolerate the clock skew of the device. The matched time step is returned.
+// If the code is not valid ErrInvalidCode is returned. If UsedCodes is set,
+// a code is accepted only once, and ErrCodeReused is returned when it is
+// replayed.
+func (totp *TOTP) VerifyWithWindow(otp string, steps int) (int64, error) {
+	return totp.verifyWithWindowAt(otp, steps, totp.NowFunc())
+}
+
+// verifyWithWindowAt implements VerifyWithWindow for the passed time. This
+// is used by every verification function
+func (totp *TOTP) verifyWithWindowAt(otp string, steps int, t time.Time) (int64, error) {
+	cleanOtp := []byte(strings.ReplaceAll(otp, " ", ""))
+	period := int64(totp.options.Period)
+	current := t.Unix() / period
+
+	// The current step is checked first, since it is the most likely
+	candidates := []int64{current}
+	for i := int64(1); i <= int64(steps); i++ {
+		candidates = append(candidates, current-i, current+i)
+	}
+
+	for _, step := range candidates {
+		if step < 0 {
+			continue
+		}
+
+		code := []byte(totp.At(time.Unix(step*period, 0)))
+		if subtle.ConstantTimeCompare(code, cleanOtp) == 1 {
+			return step, totp.useCode(step)
+		}
+	}
+
+	return 0, ErrInvalidCode
+}
+
+// useCode marks the time step of a code as used. The account name of the
+// options identifies the account in the store
+func (totp *TOTP) useCode(step int64) error {
+	if totp.UsedCodes == nil {
+		return nil
+	}
+
+	if totp.options.Account == "" {
+		return fmt.Errorf("cannot check used codes: %w", ErrInvalidOptions)
+	}
+
+	firstUse, err := totp.UsedCodes.Use(totp.options.Account, step)
+	if err != nil {
+		return fmt.Errorf("cannot check used codes: %w", err)
+	}
+
+	if !firstUse {
+		return ErrCodeReused
+	}
+
+	return nil
+}
+
+// ProvisioningUri gets the `otpauth://totp/` URI that should be read by a
+// mobile device to create the account. The digits, the period and the
+// algorithm are included when they are not the default ones. If the account
+// name or the issuer name are empty, the ones of the options are used
+func (totp *TOTP) ProvisioningUri(accountName string, issuerName string) string {
+	params := url.Values{}
+	if totp.options.Period != DefaultPeriod {
+		params.Set("period", strconv.Itoa(totp.options.Period))
+	}
+
+	return totp.options.provisioningURI(otpTypeTOTP, totp.secret, accountName, issuerName, params)
 }
 
 // GetQRCodeAsPNG create a new PNG file (256x256) with the QR code that should
@@ -40,3 +249,96 @@ func (totp *TOTP) GetQRCodeAsPNG(accountName string, issuerName string) ([]byte,
 func CreateRandomSecret() string {
 	return gotp.RandomSecret(32)
 }
+
+// normalized gets a copy of these options with the default values applied
+func (options Options) normalized() Options {
+	if options.Digits == 0 {
+		options.Digits = DefaultDigits
+	}
+
+	if options.Period == 0 {
+		options.Period = DefaultPeriod
+	}
+
+	if options.Algorithm == "" {
+		options.Algorithm = AlgorithmSHA1
+	}
+	options.Algorithm = strings.ToUpper(options.Algorithm)
+
+	return options
+}
+
+// hasher gets the HMAC hash function of these options, checking the number
+// of digits too
+func (options Options) hasher() (*gotp.Hasher, error) {
+	if options.Digits < DefaultDigits || options.Digits > maxDigits {
+		return nil, ErrInvalidOptions
+	}
+
+	var digest func() hash.Hash
+	switch options.Algorithm {
+	case AlgorithmSHA1:
+		digest = sha1.New
+	case AlgorithmSHA256:
+		digest = sha256.New
+	case AlgorithmSHA512:
+		digest = sha512.New
+	default:
+		return nil, ErrInvalidOptions
+	}
+
+	// The name is lowercase since gotp omits "sha1" from the URIs
+	return &gotp.Hasher{HashName: strings.ToLower(options.Algorithm), Digest: digest}, nil
+}
+
+// provisioningURI builds an URI in the Key URI Format, adding the options to
+// the passed parameters. If the account name or the issuer name are empty,
+// the ones of these options are used. Spaces are encoded as "%20", since
+// many authenticator Apps don't decode "+", and the padding of the secret is
+// removed
+func (options Options) provisioningURI(
+	otpType string, secret string, accountName string, issuerName string, params url.Values) string {
+	if accountName == "" {
+		accountName = options.Account
+	}
+
+	if issuerName == "" {
+		issuerName = options.Issuer
+	}
+
+	label := url.PathEscape(accountName)
+	params.Set("secret", strings.TrimRight(secret, "="))
+	if issuerName != "" {
+		label = url.PathEscape(issuerName) + ":" + label
+		params.Set("issuer", issuerName)
+	}
+
+	if options.Algorithm != AlgorithmSHA1 {
+		params.Set("algorithm", options.Algorithm)
+	}
+
+	if options.Digits != DefaultDigits {
+		params.Set("digits", strconv.Itoa(options.Digits))
+	}
+
+	query := strings.ReplaceAll(params.Encode(), "+", "%20")
+	return fmt.Sprintf("otpauth://%s/%s?%s", otpType, label, query)
+}
+
+// checkSecret check if a secret is base32 encoded, since gotp panics while
+// generating the codes otherwise
+func checkSecret(secret string) error {
+	if secret == "" {
+		return ErrInvalidSecret
+	}
+
+	if missingPadding := len(secret) % 8; missingPadding != 0 {
+		secret += strings.Repeat("=", 8-missingPadding)
+	}
+
+	if _, err := base32.StdEncoding.DecodeString(secret); err != nil {
+		return ErrInvalidSecret
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package otp
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	// The secrets of the RFC 6238 test vectors, which are the ASCII string
+	// "12345678901234567890" repeated to the length of the hash function
+	rfc6238Secrets = map[string]string{
+		AlgorithmSHA1:   "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		AlgorithmSHA256: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA====",
+		AlgorithmSHA512: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" +
+			"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNA=",
+	}
+
+	// The RFC 6238 test vectors, from appendix B
+	rfc6238Vectors = []struct {
+		timestamp int64
+		codes     map[string]string
+	}{
+		{59, map[string]string{
+			AlgorithmSHA1: "94287082", AlgorithmSHA256: "46119246", AlgorithmSHA512: "90693936"}},
+		{1111111109, map[string]string{
+			AlgorithmSHA1: "07081804", AlgorithmSHA256: "68084774", AlgorithmSHA512: "25091201"}},
+		{1111111111, map[string]string{
+			AlgorithmSHA1: "14050471", AlgorithmSHA256: "67062674", AlgorithmSHA512: "99943326"}},
+		{1234567890, map[string]string{
+			AlgorithmSHA1: "89005924", AlgorithmSHA256: "91819424", AlgorithmSHA512: "93441116"}},
+		{2000000000, map[string]string{
+			AlgorithmSHA1: "69279037", AlgorithmSHA256: "90698825", AlgorithmSHA512: "38618901"}},
+		{20000000000, map[string]string{
+			AlgorithmSHA1: "65353130", AlgorithmSHA256: "77737706", AlgorithmSHA512: "47863826"}},
+	}
+)
+
+func TestRFC6238Vectors(t *testing.T) {
+	for algorithm, secret := range rfc6238Secrets {
+		totp, err := NewTOTPWithOptions(secret, Options{Digits: 8, Algorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, vector := range rfc6238Vectors {
+			if code := totp.At(time.Unix(vector.timestamp, 0)); code != vector.codes[algorithm] {
+				t.Errorf("Wrong %v code at %v: %v instead of %v",
+					algorithm, vector.timestamp, code, vector.codes[algorithm])
+			}
+		}
+	}
+}
+
+func TestDefaultOptions(t *testing.T) {
+	totp, err := NewTOTPWithOptions(rfc6238Secrets[AlgorithmSHA1], Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := totp.At(time.Unix(59, 0)); code != "287082" {
+		t.Errorf("Wrong code: %v", code)
+	}
+
+	if code := NewTOTP(rfc6238Secrets[AlgorithmSHA1]).At(time.Unix(59, 0)); code != "287082" {
+		t.Errorf("Wrong code: %v", code)
+	}
+}
+
+func TestProvisioningUriWithOptions(t *testing.T) {
+	totp, err := NewTOTPWithOptions(rfc6238Secrets[AlgorithmSHA1], Options{
+		Digits:    8,
+		Period:    60,
+		Algorithm: AlgorithmSHA256,
+		Issuer:    "My Company",
+		Account:   "test.user@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawURI := totp.ProvisioningUri("", "")
+	if strings.Contains(rawURI, "+") {
+		t.Errorf("Spaces encoded as '+': %v", rawURI)
+	}
+
+	uri, err := url.Parse(rawURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/My Company:test.user@example.com" {
+		t.Errorf("Wrong URI: %v", uri)
+	}
+
+	query := uri.Query()
+	expected := map[string]string{
+		"secret":    rfc6238Secrets[AlgorithmSHA1],
+		"issuer":    "My Company",
+		"algorithm": "SHA256",
+		"digits":    "8",
+		"period":    "60",
+	}
+	for name, value := range expected {
+		if query.Get(name) != value {
+			t.Errorf("Wrong %v: %v", name, query.Get(name))
+		}
+	}
+
+	// The passed names take precedence over the options
+	uri, err = url.Parse(totp.ProvisioningUri("other.user@example.com", "Other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Path != "/Other:other.user@example.com" || uri.Query().Get("issuer") != "Other" {
+		t.Errorf("Wrong URI: %v", uri)
+	}
+
+	// The padding of the secret is removed
+	totp, err = NewTOTPWithOptions(rfc6238Secrets[AlgorithmSHA256], Options{Algorithm: AlgorithmSHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri, err = url.Parse(totp.ProvisioningUri("test.user@example.com", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Query().Get("secret") != strings.TrimRight(rfc6238Secrets[AlgorithmSHA256], "=") {
+		t.Errorf("Wrong secret: %v", uri)
+	}
+
+	png, err := totp.GetQRCodeAsPNG("", "")
+	if err != nil || len(png) == 0 {
+		t.Errorf("Cannot create QR code: %v", err)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	invalidOptions := []Options{
+		{Digits: 4},
+		{Digits: 9},
+		{Period: -30},
+		{Algorithm: "MD5"},
+	}
+
+	for _, options := range invalidOptions {
+		_, err := NewTOTPWithOptions(rfc6238Secrets[AlgorithmSHA1], options)
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Invalid options accepted: %v", options)
+		}
+	}
+
+	for _, secret := range []string{"", "not base32!"} {
+		if _, err := NewTOTPWithOptions(secret, Options{}); !errors.Is(err, ErrInvalidSecret) {
+			t.Errorf("Invalid secret accepted: %q", secret)
+		}
+	}
+}
+
+func createTestTOTP(t *testing.T, account string, now *time.Time) *TOTP {
+	totp, err := NewTOTPWithOptions(rfc6238Secrets[AlgorithmSHA1], Options{Account: account})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	totp.NowFunc = func() time.Time {
+		return *now
+	}
+	return totp
+}
+
+func TestClock(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	totp := createTestTOTP(t, "", &now)
+
+	if code := totp.Now(); code != "050471" {
+		t.Errorf("Wrong code: %v", code)
+	}
+
+	code, expiration := totp.NowWithExpiration()
+	if code != "050471" || expiration != 1111111140 {
+		t.Errorf("Wrong code or expiration: %v %v", code, expiration)
+	}
+
+	if !totp.Verify("050471") || !totp.Verify("050 471") {
+		t.Error("Cannot verify code")
+	}
+
+	if !totp.VerifyAt("081804", time.Unix(1111111109, 0)) {
+		t.Error("Cannot verify code at the passed time")
+	}
+
+	now = now.Add(30 * time.Second)
+	if totp.Verify("050471") {
+		t.Error("Expired code accepted")
+	}
+}
+
+func TestVerifyWithWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	totp := createTestTOTP(t, "", &now)
+
+	tests := []struct {
+		timestamp int64
+		steps     int
+		expected  error
+	}{
+		{1111111111, 0, nil},
+		{1111111111 - 30, 0, ErrInvalidCode},
+		{1111111111 - 30, 1, nil},
+		{1111111111 + 30, 1, nil},
+		{1111111111 - 60, 1, ErrInvalidCode},
+		{1111111111 + 60, 2, nil},
+	}
+
+	for _, test := range tests {
+		step, err := totp.VerifyWithWindow(totp.At(time.Unix(test.timestamp, 0)), test.steps)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("Code at %v with %v steps: expected %v, got %v", test.timestamp, test.steps, test.expected, err)
+		}
+
+		if err == nil && step != test.timestamp/DefaultPeriod {
+			t.Errorf("Wrong step: %v instead of %v", step, test.timestamp/DefaultPeriod)
+		}
+	}
+
+	if _, err := totp.VerifyWithWindow("abcdef", 1); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Invalid code accepted: %v", err)
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	totp := createTestTOTP(t, "test.user@example.com", &now)
+	totp.UsedCodes = NewMemoryUsedCodeStore()
+
+	code := totp.Now()
+	if _, err := totp.VerifyWithWindow(code, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := totp.VerifyWithWindow(code, 1); !errors.Is(err, ErrCodeReused) {
+		t.Errorf("Code replayed: %v", err)
+	}
+
+	if totp.Verify(code) {
+		t.Error("Code replayed")
+	}
+
+	// The codes before the last used one are rejected too
+	previousCode := totp.At(now.Add(-30 * time.Second))
+	if _, err := totp.VerifyWithWindow(previousCode, 1); !errors.Is(err, ErrCodeReused) {
+		t.Errorf("Old code accepted after a newer one: %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if !totp.Verify(totp.Now()) {
+		t.Error("Cannot verify new code")
+	}
+
+	// Other accounts are not affected
+	otherTOTP := createTestTOTP(t, "other.user@example.com", &now)
+	otherTOTP.UsedCodes = totp.UsedCodes
+	if _, err := otherTOTP.VerifyWithWindow(code, 1); err != nil {
+		t.Error("Cannot verify code", err)
+	}
+
+	// The account is needed to check the used codes
+	anonymousTOTP := createTestTOTP(t, "", &now)
+	anonymousTOTP.UsedCodes = totp.UsedCodes
+	if _, err := anonymousTOTP.VerifyWithWindow(code, 1); err == nil {
+		t.Error("Code accepted without account")
+	}
+}
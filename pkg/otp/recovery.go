@@ -0,0 +1,120 @@
+package otp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Mind-Informatica-srl/idcrypt/internal/hash"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/keygen"
+	"github.com/Mind-Informatica-srl/idcrypt/internal/utils"
+)
+
+/*
+Recovery codes let the users log in when they lose their OTP device. Every
+code can be used once, and only its hash is stored.
+
+The codes are composed by 10 characters of the Crockford base32 alphabet,
+which excludes the letters that can be confused with digits, in two groups
+of 5 characters:
+
+	7K2QD-M9XWA
+
+so every code has 50 bits of entropy.
+*/
+
+const (
+	// recoveryCodeAlphabet is the Crockford base32 alphabet. Its length is a
+	// power of two, so reducing random bytes modulo the length gives an
+	// uniform distribution
+	recoveryCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+	recoveryCodeLen       = 10
+	recoveryCodeGroupLen  = 5
+	recoveryCodeSeparator = "-"
+)
+
+var (
+	// recoveryCodeHashParams are the parameters used to hash the recovery
+	// codes. They are lighter than hash.DefaultParams since every code is
+	// checked at every attempt, but the codes are short and still need a
+	// slow hash. These are the minimum parameters recommended by OWASP
+	recoveryCodeHashParams = keygen.Params{
+		Algorithm:   keygen.AlgorithmArgon2id,
+		Iterations:  2,
+		Memory:      19 * 1024,
+		Parallelism: 1,
+	}
+)
+
+// GenerateRecoveryCodes generates `n` random recovery codes. The codes are
+// returned to be shown to the user, together with their hashes, which must
+// be stored in place of the codes. The hashes are PHC strings, see
+// internal/hash
+func GenerateRecoveryCodes(n int) ([]string, []string, error) {
+	if n <= 0 {
+		return nil, nil, fmt.Errorf("GenerateRecoveryCodes: invalid number of codes %v", n)
+	}
+
+	codes := make([]string, n)
+	hashes := make([]string, n)
+	for i := range codes {
+		random, err := utils.GenerateSalt(recoveryCodeLen)
+		if err != nil {
+			return nil, nil, fmt.Errorf("GenerateRecoveryCodes: %v", err)
+		}
+
+		var code strings.Builder
+		for j, value := range random {
+			if j > 0 && j%recoveryCodeGroupLen == 0 {
+				code.WriteString(recoveryCodeSeparator)
+			}
+			code.WriteByte(recoveryCodeAlphabet[int(value)%len(recoveryCodeAlphabet)])
+		}
+
+		codeHash, err := hash.CryptWithParams([]byte(normalizeRecoveryCode(code.String())), recoveryCodeHashParams)
+		if err != nil {
+			return nil, nil, fmt.Errorf("GenerateRecoveryCodes: %v", err)
+		}
+
+		codes[i] = code.String()
+		hashes[i] = string(codeHash)
+	}
+
+	return codes, hashes, nil
+}
+
+// ConsumeRecoveryCode check if the passed code corresponds to one of the
+// stored hashes, returning the index of the matched hash, which must be
+// deleted since every code can be used only once. Every hash is checked, so
+// the time taken doesn't reveal the matched one. The code is case insensitive
+// and the separators and spaces are ignored. If the code is not valid
+// ErrInvalidCode is returned.
+func ConsumeRecoveryCode(code string, hashes []string) (int, error) {
+	normalizedCode := []byte(normalizeRecoveryCode(code))
+
+	matched := -1
+	for i, codeHash := range hashes {
+		valid, err := hash.Check(normalizedCode, []byte(codeHash))
+		if err != nil {
+			return -1, fmt.Errorf("ConsumeRecoveryCode, invalid hash: %v", err)
+		}
+
+		if valid && matched < 0 {
+			matched = i
+		}
+	}
+
+	if matched < 0 {
+		return -1, ErrInvalidCode
+	}
+
+	return matched, nil
+}
+
+// normalizeRecoveryCode removes the separators and the spaces from a code
+// and converts it to uppercase
+func normalizeRecoveryCode(code string) string {
+	code = strings.ReplaceAll(code, recoveryCodeSeparator, "")
+	code = strings.ReplaceAll(code, " ", "")
+	return strings.ToUpper(code)
+}
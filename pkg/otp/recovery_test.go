@@ -0,0 +1,72 @@
+package otp
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != 5 || len(hashes) != 5 {
+		t.Fatalf("Wrong number of codes: %v %v", len(codes), len(hashes))
+	}
+
+	format := regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{5}-[0-9A-HJKMNP-TV-Z]{5}$`)
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("Wrong code format: %v", code)
+		}
+
+		if seen[code] {
+			t.Errorf("Code repeated: %v", code)
+		}
+		seen[code] = true
+
+		if strings.Contains(hashes[i], code) || !strings.HasPrefix(hashes[i], "$argon2id$") {
+			t.Errorf("Wrong hash: %v", hashes[i])
+		}
+	}
+
+	if _, _, err = GenerateRecoveryCodes(0); err == nil {
+		t.Error("Zero codes generated")
+	}
+}
+
+func TestConsumeRecoveryCode(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ConsumeRecoveryCode(codes[1], hashes)
+	if err != nil || index != 1 {
+		t.Fatalf("Cannot consume code: %v %v", index, err)
+	}
+
+	// The code is case insensitive and the separators are optional
+	relaxedCode := strings.ToLower(strings.ReplaceAll(codes[2], "-", " "))
+	if index, err = ConsumeRecoveryCode(relaxedCode, hashes); err != nil || index != 2 {
+		t.Errorf("Cannot consume code: %v %v", index, err)
+	}
+
+	// Once the hash is deleted the code can't be used anymore
+	hashes = append(hashes[:1], hashes[2:]...)
+	if _, err = ConsumeRecoveryCode(codes[1], hashes); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Used code accepted: %v", err)
+	}
+
+	if _, err = ConsumeRecoveryCode("00000-00000", hashes); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Invalid code accepted: %v", err)
+	}
+
+	if _, err = ConsumeRecoveryCode(codes[0], []string{"not an hash"}); err == nil {
+		t.Error("Invalid hash accepted")
+	}
+}
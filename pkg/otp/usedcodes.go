@@ -0,0 +1,46 @@
+package otp
+
+import (
+	"sync"
+)
+
+// UsedCodeStore stores the time steps of the codes already used by every
+// account, so that a code can't be replayed. Implementations must be safe for
+// concurrent use
+type UsedCodeStore interface {
+	// Use marks the time step of a code as used for an account, returning
+	// false if it was already used. This must be atomic, since two
+	// concurrent logins with the same code must be detected as a replay
+	Use(account string, step int64) (bool, error)
+}
+
+// MemoryUsedCodeStore is a UsedCodeStore keeping the last used time step of
+// every account in memory. The time steps before the last used one are
+// considered used too, as suggested by RFC 6238, so a code older than the
+// last accepted one is rejected. It is safe for concurrent use, but it can't
+// be shared among many processes.
+type MemoryUsedCodeStore struct {
+	mutex    sync.Mutex
+	lastUsed map[string]int64
+}
+
+// NewMemoryUsedCodeStore creates an empty MemoryUsedCodeStore
+func NewMemoryUsedCodeStore() *MemoryUsedCodeStore {
+	return &MemoryUsedCodeStore{
+		lastUsed: make(map[string]int64),
+	}
+}
+
+// Use marks the time step of a code as used for an account, returning false
+// if it, or a later one, was already used
+func (store *MemoryUsedCodeStore) Use(account string, step int64) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if last, ok := store.lastUsed[account]; ok && step <= last {
+		return false, nil
+	}
+
+	store.lastUsed[account] = step
+	return true, nil
+}